@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"pion-conference/pkg/bot"
+
+	"github.com/go-chi/chi"
+)
+
+type BotHandler struct {
+	Bots *bot.Manager
+}
+
+func (h BotHandler) Start(w http.ResponseWriter, r *http.Request) {
+	var options bot.Options
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid bot request: %w", err))
+		return
+	}
+
+	started, err := h.Bots.Start(chi.URLParam(r, "room_id"), options)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"clientId": started.ID(),
+		"nickname": started.Nickname(),
+		"room":     started.Room(),
+	})
+}
+
+func (h BotHandler) Stop(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "bot_id")
+
+	running, ok := h.Bots.Bot(id)
+	if !ok || running.Room() != chi.URLParam(r, "room_id") {
+		writeError(w, http.StatusNotFound, bot.ErrBotNotFound)
+		return
+	}
+
+	err := h.Bots.Stop(id)
+	if errors.Is(err, bot.ErrBotNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"pion-conference/pkg/chat"
+
+	"github.com/go-chi/chi"
+)
+
+type ChatHandler struct {
+	Store chat.Store
+}
+
+// Export returns the chat history of the room, it is kept for the chat retention after the room ended.
+// Delete removes it right after the export.
+func (h ChatHandler) Export(w http.ResponseWriter, r *http.Request) {
+	history, err := h.Store.History(chi.URLParam(r, "room_id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("unable to export chat: %w", err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (h ChatHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.Store.Delete(chi.URLParam(r, "room_id")); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"pion-conference/pkg/webrtc"
+
+	"github.com/go-chi/chi"
+)
+
+type CodecsHandler struct{}
+
+func (h CodecsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	policy := webrtc.DefaultCodecPolicy
+	if roomCtrl, ok := webrtc.GetRoomsService().GetRoomController(chi.URLParam(r, "room_id")); ok {
+		policy = roomCtrl.CodecPolicy()
+	}
+
+	writeJSON(w, http.StatusOK, policy)
+}
+
+// Set configures the room before or while participants join, it creates the room when it does not exist yet
+func (h CodecsHandler) Set(w http.ResponseWriter, r *http.Request) {
+	var policy webrtc.CodecPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid codec policy: %w", err))
+		return
+	}
+
+	roomCtrl := webrtc.GetRoomsService().SetRoomController(chi.URLParam(r, "room_id"))
+	if err := roomCtrl.SetCodecPolicy(policy); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, policy)
+}
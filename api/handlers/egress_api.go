@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"pion-conference/pkg/models/api"
+	"pion-conference/pkg/webrtc"
+
+	"github.com/go-chi/chi"
+)
+
+type EgressHandler struct{}
+
+func (h EgressHandler) Start(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	var request api.EgressStart
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid egress request: %w", err))
+		return
+	}
+	if request.ClientId == "" || request.Host == "" {
+		writeError(w, http.StatusBadRequest, errors.New("clientId and host are required"))
+		return
+	}
+
+	session, err := roomCtrl.StartEgress(request.ClientId, request.Host, request.Port)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, api.EgressSession{
+		Id:       session.ID(),
+		ClientId: session.ClientID(),
+		Host:     session.Host(),
+		Port:     session.Port(),
+		SDP:      session.SDP(),
+	})
+}
+
+func (h EgressHandler) SDP(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	session, ok := roomCtrl.Egress(chi.URLParam(r, "egress_id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, webrtc.ErrEgressNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", session.ID()+".sdp"))
+	_, _ = w.Write([]byte(session.SDP()))
+}
+
+func (h EgressHandler) Stop(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	err := roomCtrl.StopEgress(chi.URLParam(r, "egress_id"))
+	if errors.Is(err, webrtc.ErrEgressNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"pion-conference/pkg/transfer"
+
+	"github.com/go-chi/chi"
+)
+
+type FilesHandler struct {
+	Files *transfer.Manager
+}
+
+// List returns the files which were stored in the room
+func (h FilesHandler) List(w http.ResponseWriter, r *http.Request) {
+	files, err := h.Files.Files(chi.URLParam(r, "room_id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, files)
+}
+
+// Download lets late joiners fetch a stored file
+func (h FilesHandler) Download(w http.ResponseWriter, r *http.Request) {
+	file, metadata, err := h.Files.Open(chi.URLParam(r, "room_id"), chi.URLParam(r, "file_id"))
+	if errors.Is(err, transfer.ErrTransferUnknown) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("file not found: %s", chi.URLParam(r, "file_id")))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer file.Close()
+
+	if metadata.MimeType != "" {
+		w.Header().Set("Content-Type", metadata.MimeType)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": metadata.Name}))
+	http.ServeContent(w, r, metadata.Name, metadata.Created, file)
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"pion-conference/pkg/webrtc"
+
+	"github.com/go-chi/chi"
+)
+
+type (
+	LastNHandler struct{}
+
+	lastN struct {
+		N int `json:"n"`
+	}
+)
+
+func (h LastNHandler) Get(w http.ResponseWriter, r *http.Request) {
+	n := webrtc.DefaultLastN
+	if roomCtrl, ok := webrtc.GetRoomsService().GetRoomController(chi.URLParam(r, "room_id")); ok {
+		n = roomCtrl.LastN()
+	}
+
+	writeJSON(w, http.StatusOK, lastN{N: n})
+}
+
+// Set changes how many of the most recent speakers have their video forwarded, 0 forwards everybody
+func (h LastNHandler) Set(w http.ResponseWriter, r *http.Request) {
+	var body lastN
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid last-n: %w", err))
+		return
+	}
+
+	roomCtrl := webrtc.GetRoomsService().SetRoomController(chi.URLParam(r, "room_id"))
+	if err := roomCtrl.SetLastN(body.N); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
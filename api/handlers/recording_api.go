@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"pion-conference/pkg/recording"
+	"pion-conference/pkg/recording/storage"
+	"pion-conference/pkg/webrtc"
+)
+
+type RecordingHandler struct {
+	Storage storage.Storage
+}
+
+func (h RecordingHandler) Start(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	mode, err := recording.ParseMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = roomCtrl.StartRecording(h.Storage, mode); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"room":      roomCtrl.Room(),
+		"mode":      mode,
+		"recording": true,
+	})
+}
+
+func (h RecordingHandler) Stop(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	manifest, err := roomCtrl.StopRecording()
+	if errors.Is(err, webrtc.ErrRecordingNotStarted) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil && manifest == nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, manifest)
+}
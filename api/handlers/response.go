@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"pion-conference/pkg/webrtc"
+
+	"github.com/go-chi/chi"
+)
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("unable to write json response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func roomController(w http.ResponseWriter, r *http.Request) (*webrtc.RoomController, bool) {
+	roomId := chi.URLParam(r, "room_id")
+
+	roomCtrl, ok := webrtc.GetRoomsService().GetRoomController(roomId)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("room not found: %s", roomId))
+		return nil, false
+	}
+	return roomCtrl, true
+}
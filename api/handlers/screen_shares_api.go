@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"pion-conference/pkg/webrtc"
+
+	"github.com/go-chi/chi"
+)
+
+type (
+	ScreenSharesHandler struct{}
+
+	screenShares struct {
+		Max     int      `json:"max"`
+		Sharers []string `json:"sharers"`
+	}
+)
+
+func (h ScreenSharesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	shares := screenShares{Max: webrtc.DefaultMaxScreenShares, Sharers: []string{}}
+	if roomCtrl, ok := webrtc.GetRoomsService().GetRoomController(chi.URLParam(r, "room_id")); ok {
+		shares.Max = roomCtrl.MaxScreenShares()
+		shares.Sharers = append(shares.Sharers, roomCtrl.ScreenSharers()...)
+	}
+
+	writeJSON(w, http.StatusOK, shares)
+}
+
+// Set changes how many participants may share their screen at the same time, 0 does not limit them
+func (h ScreenSharesHandler) Set(w http.ResponseWriter, r *http.Request) {
+	var body screenShares
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid screen shares: %w", err))
+		return
+	}
+
+	roomCtrl := webrtc.GetRoomsService().SetRoomController(chi.URLParam(r, "room_id"))
+	if err := roomCtrl.SetMaxScreenShares(body.Max); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, screenShares{Max: body.Max, Sharers: append([]string{}, roomCtrl.ScreenSharers()...)})
+}
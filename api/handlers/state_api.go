@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"pion-conference/pkg/webrtc"
+
+	"github.com/go-chi/chi"
+)
+
+type (
+	StateHandler struct{}
+
+	stateBody struct {
+		Value   json.RawMessage `json:"value"`
+		Version *uint64         `json:"version,omitempty"`
+	}
+)
+
+func (h StateHandler) Get(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, roomCtrl.State())
+}
+
+// Set writes a key like a participant, the body is {"value": ..., "version": 3} where version makes it a compare-and-set
+func (h StateHandler) Set(w http.ResponseWriter, r *http.Request) {
+	var body stateBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid state: %w", err))
+		return
+	}
+
+	roomCtrl := webrtc.GetRoomsService().SetRoomController(chi.URLParam(r, "room_id"))
+	h.update(w, roomCtrl, webrtc.StateUpdate{Key: chi.URLParam(r, "key"), Value: body.Value, Version: body.Version})
+}
+
+// Delete removes a key, the version query parameter makes it a compare-and-set
+func (h StateHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	update := webrtc.StateUpdate{Key: chi.URLParam(r, "key"), Delete: true}
+	if value := r.URL.Query().Get("version"); value != "" {
+		version, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid version: %s", value))
+			return
+		}
+		update.Version = &version
+	}
+	h.update(w, roomCtrl, update)
+}
+
+// Clear deletes all keys of the room
+func (h StateHandler) Clear(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	roomCtrl.ClearState()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h StateHandler) update(w http.ResponseWriter, roomCtrl *webrtc.RoomController, update webrtc.StateUpdate) {
+	entry, err := roomCtrl.UpdateState("", update)
+	switch {
+	case errors.Is(err, webrtc.ErrStateConflict):
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error(), "entry": entry})
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, http.StatusOK, entry)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"pion-conference/pkg/webrtc"
+	"pion-conference/pkg/whip"
+
+	"github.com/go-chi/chi"
+	pion "github.com/pion/webrtc/v3"
+)
+
+type WhepHandler struct{}
+
+// View answers the offer of a WHEP player. The player offers one recvonly section per track it wants to receive,
+// the sections which get no track yet receive the tracks published later without renegotiation.
+func (h WhepHandler) View(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	offer, ok := readBody(w, r, sdpContentType)
+	if !ok {
+		return
+	}
+
+	viewer, answer, err := roomCtrl.AddViewer(r.URL.Query().Get("clientId"), pion.SessionDescription{
+		Type: pion.SDPTypeOffer,
+		SDP:  offer,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", sdpContentType)
+	w.Header().Set("Location", fmt.Sprintf("/whep/%s/%s", roomCtrl.Room(), viewer.ID()))
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(answer.SDP))
+}
+
+func (h WhepHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	body, ok := readBody(w, r, sdpFragContentType)
+	if !ok {
+		return
+	}
+
+	fragment, err := whip.ParseFragment(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if fragment.IsRestart() {
+		writeError(w, http.StatusUnprocessableEntity, errors.New("ice restart is not supported for viewers"))
+		return
+	}
+
+	viewerId := chi.URLParam(r, "viewer_id")
+	for _, candidate := range fragment.Candidates {
+		err = roomCtrl.AddViewerICECandidate(viewerId, candidate)
+		if errors.Is(err, webrtc.ErrViewerNotFound) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h WhepHandler) Stop(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	err := roomCtrl.RemoveViewer(chi.URLParam(r, "viewer_id"))
+	if errors.Is(err, webrtc.ErrViewerNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h WhepHandler) Audience(w http.ResponseWriter, r *http.Request) {
+	roomCtrl, ok := roomController(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{
+		"participants": roomCtrl.ParticipantsCount(),
+		"viewers":      roomCtrl.ViewersCount(),
+	})
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"pion-conference/pkg/whip"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	sdpContentType     = "application/sdp"
+	sdpFragContentType = "application/trickle-ice-sdpfrag"
+
+	// maxSDPSize limits the offer and the fragment bodies
+	maxSDPSize = 64 * 1024
+)
+
+type WhipHandler struct {
+	Whip *whip.Service
+}
+
+func (h WhipHandler) Publish(w http.ResponseWriter, r *http.Request) {
+	offer, ok := readBody(w, r, sdpContentType)
+	if !ok {
+		return
+	}
+
+	roomId := chi.URLParam(r, "room_id")
+	session, answer, err := h.Whip.Publish(roomId, offer)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", sdpContentType)
+	w.Header().Set("Location", fmt.Sprintf("/whip/%s/%s", roomId, session.ID()))
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(answer))
+}
+
+func (h WhipHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.session(w, r)
+	if !ok {
+		return
+	}
+
+	body, ok := readBody(w, r, sdpFragContentType)
+	if !ok {
+		return
+	}
+
+	fragment, err := whip.ParseFragment(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	answer, err := session.Patch(fragment)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if answer == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", sdpFragContentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(answer))
+}
+
+func (h WhipHandler) Stop(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.session(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Whip.Stop(session.ID())
+	if errors.Is(err, whip.ErrSessionNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h WhipHandler) session(w http.ResponseWriter, r *http.Request) (*whip.Session, bool) {
+	session, ok := h.Whip.Session(chi.URLParam(r, "session_id"))
+	if !ok || session.Room() != chi.URLParam(r, "room_id") {
+		writeError(w, http.StatusNotFound, whip.ErrSessionNotFound)
+		return nil, false
+	}
+	return session, true
+}
+
+func readBody(w http.ResponseWriter, r *http.Request, contentType string) (string, bool) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != contentType {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("content type must be %s", contentType))
+		return "", false
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSDPSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unable to read request body: %w", err))
+		return "", false
+	}
+	return string(body), true
+}
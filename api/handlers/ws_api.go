@@ -56,4 +56,8 @@ func (h WsHandler) CreateRoom(w http.ResponseWriter, r *http.Request) {
 			log.Printf("[%s] Error handling websocket message: %s", subscription.ClientID, err)
 		}
 	}
+
+	if err := socketHandler.Close(); err != nil {
+		log.Printf("[%s] Error closing the connection: %s", subscription.ClientID, err)
+	}
 }
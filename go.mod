@@ -7,6 +7,8 @@ require (
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/websocket v1.4.2
 	github.com/pion/rtcp v1.2.3
+	github.com/pion/rtp v1.5.5
+	github.com/pion/sdp/v2 v2.3.9
 	github.com/pion/webrtc/v3 v3.0.0-20200708045954-020aebd5f492
 	golang.org/x/net v0.0.0-20200707034311-ab3426394381 // indirect
 )
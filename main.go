@@ -1,10 +1,21 @@
 package main
 
 import (
+	"expvar"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
+
 	"pion-conference/api/handlers"
+	"pion-conference/pkg/bot"
+	"pion-conference/pkg/chat"
+	"pion-conference/pkg/recording/storage"
+	"pion-conference/pkg/transfer"
 	"pion-conference/pkg/webrtc"
+	"pion-conference/pkg/whip"
 	"pion-conference/pkg/ws"
 
 	"github.com/go-chi/chi"
@@ -12,22 +23,206 @@ import (
 )
 
 func main() {
+	//should be initialized once at the start of the service
+	ws.InitRoomsService()
+	webrtc.InitRoomsService()
+
+	if codecs := os.Getenv("CODECS"); codecs != "" {
+		policy, err := webrtc.ParseCodecPolicy(codecs)
+		if err != nil {
+			log.Fatalf("invalid CODECS: %s", err)
+		}
+		webrtc.DefaultCodecPolicy = policy
+	}
+
+	if lastN := os.Getenv("LAST_N"); lastN != "" {
+		n, err := strconv.Atoi(lastN)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid LAST_N: %s", lastN)
+		}
+		webrtc.DefaultLastN = n
+	}
+
+	if maxScreenShares := os.Getenv("MAX_SCREEN_SHARES"); maxScreenShares != "" {
+		n, err := strconv.Atoi(maxScreenShares)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid MAX_SCREEN_SHARES: %s", maxScreenShares)
+		}
+		webrtc.DefaultMaxScreenShares = n
+	}
+
+	if queueSize := os.Getenv("DATA_CHANNEL_QUEUE_SIZE"); queueSize != "" {
+		n, err := strconv.Atoi(queueSize)
+		if err != nil || n <= 0 {
+			log.Fatalf("invalid DATA_CHANNEL_QUEUE_SIZE: %s", queueSize)
+		}
+		webrtc.DefaultSendQueueSize = n
+	}
+
+	if dropPolicy := os.Getenv("DATA_CHANNEL_DROP_POLICY"); dropPolicy != "" {
+		policy, err := webrtc.ParseDropPolicy(dropPolicy)
+		if err != nil {
+			log.Fatalf("invalid DATA_CHANNEL_DROP_POLICY: %s", err)
+		}
+		webrtc.DefaultDropPolicy = policy
+	}
+
 	r := chi.NewRouter()
 
 	wsHandlers := handlers.WsHandler{}
+	recordingsStorage, err := newRecordingsStorage()
+	if err != nil {
+		log.Fatalf("unable to init recordings storage: %s", err)
+	}
+	recordingHandlers := handlers.RecordingHandler{Storage: recordingsStorage}
+	chatStore, err := newChatStore()
+	if err != nil {
+		log.Fatalf("unable to init chat store: %s", err)
+	}
+	webrtc.ChatStore = chatStore
+	chatHandlers := handlers.ChatHandler{Store: chatStore}
+	fileLimits := transfer.DefaultLimits()
+	if value := os.Getenv("FILE_MAX_SIZE"); value != "" {
+		if fileLimits.MaxFileSize, err = strconv.ParseInt(value, 10, 64); err != nil || fileLimits.MaxFileSize <= 0 {
+			log.Fatalf("invalid FILE_MAX_SIZE: %s", value)
+		}
+	}
+	if value := os.Getenv("FILE_MAX_TRANSFERS"); value != "" {
+		if fileLimits.MaxTransfers, err = strconv.Atoi(value); err != nil || fileLimits.MaxTransfers < 0 {
+			log.Fatalf("invalid FILE_MAX_TRANSFERS: %s", value)
+		}
+	}
+	if value := os.Getenv("FILE_ROOM_QUOTA"); value != "" {
+		if fileLimits.RoomQuota, err = strconv.ParseInt(value, 10, 64); err != nil || fileLimits.RoomQuota < 0 {
+			log.Fatalf("invalid FILE_ROOM_QUOTA: %s", value)
+		}
+	}
+	if value := os.Getenv("FILE_IDLE_TIMEOUT"); value != "" {
+		if fileLimits.IdleTimeout, err = time.ParseDuration(value); err != nil || fileLimits.IdleTimeout < 0 {
+			log.Fatalf("invalid FILE_IDLE_TIMEOUT: %s", value)
+		}
+	}
+	webrtc.Files = transfer.NewManager(envOrDefault("FILES_DIR", "files"), fileLimits)
+	filesHandlers := handlers.FilesHandler{Files: webrtc.Files}
+	egressHandlers := handlers.EgressHandler{}
+	botHandlers := handlers.BotHandler{
+		Bots: bot.NewManager(envOrDefault("MEDIA_DIR", "media"), ws.GetRoomsService(), webrtc.GetRoomsService()),
+	}
+	whipHandlers := handlers.WhipHandler{Whip: whip.NewService(webrtc.GetRoomsService())}
+	whepHandlers := handlers.WhepHandler{}
+	codecsHandlers := handlers.CodecsHandler{}
+	lastNHandlers := handlers.LastNHandler{}
+	screenSharesHandlers := handlers.ScreenSharesHandler{}
+	stateHandlers := handlers.StateHandler{}
 	// A good base middleware stack
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Recoverer)
 
+	// Dropped data channel messages and the other expvar metrics
+	r.Handle("/debug/vars", expvar.Handler())
+
 	r.Route("/ws", func(r chi.Router) {
 		r.Get("/{room_id}/{user_id}", wsHandlers.CreateRoom)
 	})
 
-	//should be initialized once at the start of the service
-	ws.InitRoomsService()
-	webrtc.InitRoomsService()
+	r.Route("/whip/{room_id}", func(r chi.Router) {
+		r.Post("/", whipHandlers.Publish)
+		r.Patch("/{session_id}", whipHandlers.Patch)
+		r.Delete("/{session_id}", whipHandlers.Stop)
+	})
+
+	r.Route("/whep/{room_id}", func(r chi.Router) {
+		r.Post("/", whepHandlers.View)
+		r.Patch("/{viewer_id}", whepHandlers.Patch)
+		r.Delete("/{viewer_id}", whepHandlers.Stop)
+	})
+
+	r.Route("/rooms/{room_id}", func(r chi.Router) {
+		r.Get("/audience", whepHandlers.Audience)
+
+		r.Get("/codecs", codecsHandlers.Get)
+		r.Put("/codecs", codecsHandlers.Set)
+
+		r.Get("/last-n", lastNHandlers.Get)
+		r.Put("/last-n", lastNHandlers.Set)
+
+		r.Get("/screen-shares", screenSharesHandlers.Get)
+		r.Put("/screen-shares", screenSharesHandlers.Set)
+
+		r.Get("/chat", chatHandlers.Export)
+		r.Delete("/chat", chatHandlers.Delete)
+
+		r.Get("/state", stateHandlers.Get)
+		r.Delete("/state", stateHandlers.Clear)
+		r.Put("/state/{key}", stateHandlers.Set)
+		r.Delete("/state/{key}", stateHandlers.Delete)
+
+		r.Get("/files", filesHandlers.List)
+		r.Get("/files/{file_id}", filesHandlers.Download)
+
+		r.Post("/recording", recordingHandlers.Start)
+		r.Delete("/recording", recordingHandlers.Stop)
+
+		r.Post("/egress", egressHandlers.Start)
+		r.Get("/egress/{egress_id}/sdp", egressHandlers.SDP)
+		r.Delete("/egress/{egress_id}", egressHandlers.Stop)
+
+		r.Post("/bots", botHandlers.Start)
+		r.Delete("/bots/{bot_id}", botHandlers.Stop)
+	})
+
+	if retention := os.Getenv("RECORDINGS_RETENTION"); retention != "" {
+		maxAge, err := time.ParseDuration(retention)
+		if err != nil {
+			log.Fatalf("invalid RECORDINGS_RETENTION: %s", err)
+		}
+		go storage.Retention{Storage: recordingsStorage, MaxAge: maxAge, Interval: time.Hour}.Run(nil)
+	}
+
+	chatRetention := chat.DefaultRetention
+	if retention := os.Getenv("CHAT_RETENTION"); retention != "" {
+		if chatRetention, err = time.ParseDuration(retention); err != nil {
+			log.Fatalf("invalid CHAT_RETENTION: %s", err)
+		}
+	}
+	go chat.Retention{Store: chatStore, MaxAge: chatRetention, Interval: time.Hour}.Run(nil)
 
 	fmt.Print("Server is running on:3000")
 	http.ListenAndServe(":3000", r)
 }
+
+func envOrDefault(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func newRecordingsStorage() (storage.Storage, error) {
+	switch backend := envOrDefault("RECORDINGS_STORAGE", "local"); backend {
+	case "local":
+		return storage.NewLocal(envOrDefault("RECORDINGS_DIR", "recordings")), nil
+	case "s3":
+		return storage.NewS3(storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown recordings storage: %s", backend)
+	}
+}
+
+func newChatStore() (chat.Store, error) {
+	switch backend := envOrDefault("CHAT_STORAGE", "memory"); backend {
+	case "memory":
+		return chat.NewMemory(chat.DefaultMaxMessages), nil
+	case "file":
+		return chat.NewFile(envOrDefault("CHAT_DIR", "chat"), chat.DefaultMaxMessages), nil
+	default:
+		return nil, fmt.Errorf("unknown chat storage: %s", backend)
+	}
+}
@@ -0,0 +1,138 @@
+// Package bot plays pre-recorded media files into a room as if they were sent by a participant.
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+
+	conference "pion-conference/pkg/webrtc"
+
+	"github.com/pion/webrtc/v3"
+)
+
+type (
+	Options struct {
+		Nickname string `json:"nickname"`
+		// Video is an IVF file with VP8 frames, Audio is an Ogg file with Opus packets
+		Video string `json:"video"`
+		Audio string `json:"audio"`
+		Loop  bool   `json:"loop"`
+	}
+
+	Bot struct {
+		id       string
+		room     string
+		nickname string
+		loop     bool
+
+		connector *conference.Connector
+		players   []player
+
+		stop     chan struct{}
+		stopOnce sync.Once
+		done     chan struct{}
+	}
+
+	player struct {
+		path   string
+		track  *webrtc.Track
+		source func(in io.ReadSeeker) (frameSource, error)
+	}
+)
+
+func newBot(id string, room string, options Options, videoPath, audioPath string) (*Bot, error) {
+	bot := &Bot{
+		id:       id,
+		room:     room,
+		nickname: options.Nickname,
+		loop:     options.Loop,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	tracks := make([]*webrtc.Track, 0, 2)
+	if videoPath != "" {
+		track, err := newTrack(webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, videoClockRate), id)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+		bot.players = append(bot.players, player{path: videoPath, track: track, source: newIVFSource})
+	}
+	if audioPath != "" {
+		track, err := newTrack(webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, audioClockRate), id)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+		bot.players = append(bot.players, player{path: audioPath, track: track, source: newOggSource})
+	}
+	if len(tracks) == 0 {
+		return nil, errors.New("bot needs a video or an audio file")
+	}
+
+	bot.connector = conference.NewStaticConnector(id, tracks)
+
+	return bot, nil
+}
+
+func (b *Bot) ID() string {
+	return b.id
+}
+
+func (b *Bot) Room() string {
+	return b.room
+}
+
+func (b *Bot) Nickname() string {
+	return b.nickname
+}
+
+func (b *Bot) Connector() *conference.Connector {
+	return b.connector
+}
+
+// Done is closed when every file has been played or the bot has been stopped
+func (b *Bot) Done() <-chan struct{} {
+	return b.done
+}
+
+func (b *Bot) start() {
+	var wg sync.WaitGroup
+
+	for _, p := range b.players {
+		wg.Add(1)
+		go func(p player) {
+			defer wg.Done()
+			if err := play(p.path, p.track, b.loop, b.stop, p.source); err != nil {
+				log.Printf("[%s] bot playback error: %s", b.id, err)
+			}
+		}(p)
+	}
+
+	go func() {
+		wg.Wait()
+		close(b.done)
+	}()
+}
+
+func (b *Bot) halt() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+	<-b.done
+}
+
+func newTrack(codec *webrtc.RTPCodec, id string) (*webrtc.Track, error) {
+	kind := codec.Type.String()
+
+	track, err := webrtc.NewTrack(codec.PayloadType, rand.Uint32()|1, kind, fmt.Sprintf("pion-%s-%s", kind, id), codec)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create bot %s track: %w", kind, err)
+	}
+	return track, nil
+}
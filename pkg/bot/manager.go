@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	mws "pion-conference/pkg/models/ws"
+	conference "pion-conference/pkg/webrtc"
+	"pion-conference/pkg/ws"
+
+	"github.com/google/uuid"
+)
+
+var ErrBotNotFound = errors.New("bot not found")
+
+// Manager starts bots from the files of mediaDir and keeps track of them
+type Manager struct {
+	mux  sync.Mutex
+	bots map[string]*Bot
+
+	mediaDir    string
+	wsRooms     *ws.RoomsService
+	webrtcRooms *conference.RoomsService
+}
+
+func NewManager(mediaDir string, wsRooms *ws.RoomsService, webrtcRooms *conference.RoomsService) *Manager {
+	return &Manager{
+		bots:        make(map[string]*Bot),
+		mediaDir:    mediaDir,
+		wsRooms:     wsRooms,
+		webrtcRooms: webrtcRooms,
+	}
+}
+
+func (m *Manager) Start(room string, options Options) (*Bot, error) {
+	videoPath, err := m.mediaPath(options.Video)
+	if err != nil {
+		return nil, err
+	}
+	audioPath, err := m.mediaPath(options.Audio)
+	if err != nil {
+		return nil, err
+	}
+
+	// The id is generated so a bot can not take over the id of a participant
+	id := "bot-" + uuid.New().String()
+	if options.Nickname == "" {
+		options.Nickname = id
+	}
+
+	m.mux.Lock()
+	if _, ok := m.bots[id]; ok {
+		m.mux.Unlock()
+		return nil, fmt.Errorf("bot already exists: %s", id)
+	}
+
+	bot, err := newBot(id, room, options, videoPath, audioPath)
+	if err != nil {
+		m.mux.Unlock()
+		return nil, err
+	}
+	m.bots[id] = bot
+	m.mux.Unlock()
+
+	if err = m.join(bot); err != nil {
+		m.mux.Lock()
+		delete(m.bots, id)
+		m.mux.Unlock()
+
+		_ = m.leave(bot)
+		return nil, err
+	}
+	bot.start()
+
+	go func() {
+		<-bot.Done()
+		if err := m.Stop(id); err != nil && !errors.Is(err, ErrBotNotFound) {
+			log.Printf("[%s] unable to stop bot: %s", id, err)
+		}
+	}()
+
+	return bot, nil
+}
+
+func (m *Manager) Stop(id string) error {
+	m.mux.Lock()
+	bot, ok := m.bots[id]
+	delete(m.bots, id)
+	m.mux.Unlock()
+
+	if !ok {
+		return ErrBotNotFound
+	}
+
+	bot.halt()
+	return m.leave(bot)
+}
+
+func (m *Manager) Bot(id string) (*Bot, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	bot, ok := m.bots[id]
+	return bot, ok
+}
+
+// join adds the bot to the roster and fans its tracks out to the room
+func (m *Manager) join(bot *Bot) error {
+	wsRoomCtrl := m.wsRooms.SetRoomController(bot.Room())
+	webrtcRoomCtrl := m.webrtcRooms.SetRoomController(bot.Room())
+	// Rooms started by a bot announce speakers and screen shares like the ones joined over the websocket
+	webrtcRoomCtrl.SetNotifier(wsRoomCtrl)
+	webrtcRoomCtrl.ReopenChat()
+
+	client := mws.NewClientWithID(nil, bot.ID())
+	client.SetMetadata(bot.Nickname())
+	wsRoomCtrl.Add(client)
+
+	if err := wsRoomCtrl.Broadcast(mws.NewMessageRoomJoin(bot.Room(), bot.ID(), bot.Nickname())); err != nil {
+		log.Printf("[%s] unable to announce bot: %s", bot.ID(), err)
+	}
+
+	connector := bot.Connector()
+	go func() {
+		// Nobody listens to the signals of a static connector, the close event has to be drained
+		for range connector.Closes() {
+		}
+	}()
+
+	if err := webrtcRoomCtrl.Add(connector); err != nil {
+		return err
+	}
+	webrtcRoomCtrl.RenegotiateAll(connector)
+	return nil
+}
+
+func (m *Manager) leave(bot *Bot) error {
+	connector := bot.Connector()
+	closeErr := connector.Close()
+
+	if webrtcRoomCtrl, ok := m.webrtcRooms.GetRoomController(bot.Room()); ok {
+		webrtcRoomCtrl.Delete(connector)
+	}
+
+	wsRoomCtrl, ok := m.wsRooms.GetRoomController(bot.Room())
+	if !ok {
+		return closeErr
+	}
+
+	wsRoomCtrl.Remove(bot.ID())
+	if wsRoomCtrl.Size() == 0 {
+		if err := m.wsRooms.DeleteRoomController(bot.Room()); err != nil {
+			log.Printf("[%s] unable to delete bot room: %s", bot.ID(), err)
+		}
+		if webrtcRoomCtrl, ok := m.webrtcRooms.GetRoomController(bot.Room()); ok {
+			webrtcRoomCtrl.EndChat()
+		}
+	} else if err := wsRoomCtrl.Broadcast(mws.NewMessageRoomLeave(bot.Room(), bot.ID())); err != nil {
+		log.Printf("[%s] unable to announce bot leave: %s", bot.ID(), err)
+	}
+
+	return closeErr
+}
+
+// mediaPath resolves the file inside mediaDir, so requests can not read arbitrary files
+func (m *Manager) mediaPath(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+
+	path := filepath.Join(m.mediaDir, filepath.Clean(string(filepath.Separator)+name))
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("media file is not available: %s", name)
+	}
+	return path, nil
+}
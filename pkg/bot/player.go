@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/ivfreader"
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+)
+
+const (
+	videoClockRate = 90000
+	audioClockRate = 48000
+)
+
+// frameSource reads the next frame of a media file with its position from the beginning of the file
+type frameSource interface {
+	next() (frame []byte, position time.Duration, samples uint32, err error)
+}
+
+// play writes the frames of the file into the track at the pace of their timestamps until stop is closed
+func play(path string, track *webrtc.Track, loop bool, stop <-chan struct{}, open func(io.ReadSeeker) (frameSource, error)) error {
+	for {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("unable to open media file: %w", err)
+		}
+
+		source, err := open(file)
+		if err != nil {
+			_ = file.Close()
+			return fmt.Errorf("unable to read media file %s: %w", path, err)
+		}
+
+		stopped, err := pace(source, track, stop)
+		_ = file.Close()
+		if err != nil || stopped || !loop {
+			return err
+		}
+	}
+}
+
+func pace(source frameSource, track *webrtc.Track, stop <-chan struct{}) (bool, error) {
+	start := time.Now()
+
+	for {
+		frame, position, samples, err := source.next()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		timer := time.NewTimer(time.Until(start.Add(position)))
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return true, nil
+		}
+
+		// io.ErrClosedPipe only means nobody subscribed to the track yet
+		if err = track.WriteSample(media.Sample{Data: frame, Samples: samples}); err != nil && err != io.ErrClosedPipe {
+			return false, fmt.Errorf("unable to write sample: %w", err)
+		}
+	}
+}
+
+type ivfSource struct {
+	reader *ivfreader.IVFReader
+	header *ivfreader.IVFFileHeader
+
+	started       bool
+	lastTimestamp uint64
+	lastSamples   uint32
+}
+
+func newIVFSource(in io.ReadSeeker) (frameSource, error) {
+	reader, header, err := ivfreader.NewWith(in)
+	if err != nil {
+		return nil, err
+	}
+	if header.TimebaseDenominator == 0 || header.TimebaseNumerator == 0 {
+		return nil, fmt.Errorf("invalid ivf timebase %d/%d", header.TimebaseNumerator, header.TimebaseDenominator)
+	}
+	return &ivfSource{reader: reader, header: header}, nil
+}
+
+func (s *ivfSource) next() ([]byte, time.Duration, uint32, error) {
+	frame, frameHeader, err := s.reader.ParseNextFrame()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	numerator, denominator := uint64(s.header.TimebaseNumerator), uint64(s.header.TimebaseDenominator)
+	position := time.Duration(frameHeader.Timestamp * numerator * uint64(time.Second) / denominator)
+
+	// The first frame of a loop keeps the frame duration, so RTP timestamps keep growing
+	samples := s.lastSamples
+	if s.started {
+		samples = uint32((frameHeader.Timestamp - s.lastTimestamp) * numerator * videoClockRate / denominator)
+	}
+	s.started = true
+	s.lastTimestamp = frameHeader.Timestamp
+	s.lastSamples = samples
+
+	return frame, position, samples, nil
+}
+
+// oggSource expects one Opus packet per page, e.g. ffmpeg -c:a libopus -page_duration 20000
+type oggSource struct {
+	reader *oggreader.OggReader
+
+	lastGranule uint64
+}
+
+func newOggSource(in io.ReadSeeker) (frameSource, error) {
+	reader, _, err := oggreader.NewWith(in)
+	if err != nil {
+		return nil, err
+	}
+	return &oggSource{reader: reader}, nil
+}
+
+func (s *oggSource) next() ([]byte, time.Duration, uint32, error) {
+	for {
+		page, pageHeader, err := s.reader.ParseNextPage()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if bytes.HasPrefix(page, []byte("OpusTags")) {
+			continue
+		}
+
+		samples := uint32(pageHeader.GranulePosition - s.lastGranule)
+		s.lastGranule = pageHeader.GranulePosition
+		position := time.Duration(pageHeader.GranulePosition * uint64(time.Second) / audioClockRate)
+
+		return page, position, samples, nil
+	}
+}
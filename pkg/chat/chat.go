@@ -0,0 +1,66 @@
+// Package chat keeps the chat history of the rooms.
+package chat
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultMaxMessages is the number of messages the stores keep per room, older ones are dropped
+	DefaultMaxMessages = 1000
+	// DefaultRetention is how long the history of an ended room is kept for export
+	DefaultRetention = 24 * time.Hour
+)
+
+type (
+	// Message is a chat message stamped by the server, Payload is whatever the client sent
+	Message struct {
+		ID      string          `json:"id"`
+		From    string          `json:"from"`
+		Time    time.Time       `json:"time"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}
+
+	// Store keeps the history of each room in the order the messages were sent.
+	// A new message reopens an ended room.
+	Store interface {
+		Append(room string, message Message) error
+		History(room string) ([]Message, error)
+		Delete(room string) error
+		// End marks that the last participant left the room at the given time
+		End(room string, at time.Time) error
+		// Reopen removes the end mark, e.g. when a participant joins again
+		Reopen(room string) error
+		// Expire deletes the history of the rooms which ended before the deadline and returns how many
+		Expire(deadline time.Time) (int, error)
+	}
+
+	// Retention deletes the history of the rooms which ended more than MaxAge ago
+	Retention struct {
+		Store    Store
+		MaxAge   time.Duration
+		Interval time.Duration
+	}
+)
+
+// Run applies the retention every Interval until stop is closed
+func (r Retention) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if deleted, err := r.Store.Expire(time.Now().Add(-r.MaxAge)); err != nil {
+			log.Printf("chat retention error: %s", err)
+		} else if deleted != 0 {
+			log.Printf("chat retention deleted the history of %d rooms", deleted)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package chat
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	root, err := ioutil.TempDir("", "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(root)
+	})
+	return root
+}
+
+func message(id string) Message {
+	return Message{ID: id, From: "carol", Time: time.Now().UTC().Truncate(time.Millisecond), Payload: json.RawMessage(`{"text":"` + id + `"}`)}
+}
+
+func ids(messages []Message) []string {
+	result := make([]string, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, message.ID)
+	}
+	return result
+}
+
+func TestStores(t *testing.T) {
+	tests := []struct {
+		name  string
+		store func(t *testing.T) Store
+	}{
+		{"memory", func(*testing.T) Store { return NewMemory(DefaultMaxMessages) }},
+		{"file", func(t *testing.T) Store { return NewFile(tempDir(t), DefaultMaxMessages) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := test.store(t)
+
+			history, err := store.History("room")
+			if err != nil || history == nil || len(history) != 0 {
+				t.Fatalf("expected an empty history, got %v, %v", history, err)
+			}
+
+			for _, id := range []string{"1", "2", "3"} {
+				if err = store.Append("room", message(id)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Rooms are separate, also when their names look like paths
+			if err = store.Append("../room", message("other")); err != nil {
+				t.Fatal(err)
+			}
+
+			history, err = store.History("room")
+			if err != nil || len(history) != 3 || history[0].ID != "1" || history[2].ID != "3" {
+				t.Fatalf("unexpected history: %v, %v", ids(history), err)
+			}
+			if history[0].From != "carol" || string(history[0].Payload) != `{"text":"1"}` {
+				t.Fatalf("unexpected message: %+v", history[0])
+			}
+
+			if err = store.Delete("room"); err != nil {
+				t.Fatal(err)
+			}
+			if history, _ = store.History("room"); len(history) != 0 {
+				t.Fatalf("expected the history to be deleted, got %v", ids(history))
+			}
+			if history, _ = store.History("../room"); len(history) != 1 {
+				t.Fatalf("expected the other room to be kept, got %v", ids(history))
+			}
+			if err = store.Delete("missing"); err != nil {
+				t.Fatalf("deleting an unknown room failed: %s", err)
+			}
+		})
+	}
+}
+
+func TestMemoryKeepsLastMessages(t *testing.T) {
+	store := NewMemory(2)
+	for _, id := range []string{"1", "2", "3"} {
+		_ = store.Append("room", message(id))
+	}
+
+	history, _ := store.History("room")
+	if got := ids(history); len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Fatalf("expected the last two messages, got %v", got)
+	}
+
+	// The history is a copy the caller may change
+	history[0].ID = "changed"
+	if again, _ := store.History("room"); again[0].ID != "2" {
+		t.Fatal("history shares its messages with the store")
+	}
+}
+
+func TestFileSurvivesRestart(t *testing.T) {
+	root := tempDir(t)
+	_ = NewFile(root, DefaultMaxMessages).Append("room", message("1"))
+
+	// A line cut by a crash is skipped
+	file, err := os.OpenFile(filepath.Join(root, "room.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString(`{"id":"2","fr` + "\n")
+	file.Close()
+
+	store := NewFile(root, DefaultMaxMessages)
+	_ = store.Append("room", message("3"))
+
+	history, err := store.History("room")
+	if got := ids(history); err != nil || len(got) != 2 || got[0] != "1" || got[1] != "3" {
+		t.Fatalf("unexpected history: %v, %v", got, err)
+	}
+}
+
+func TestStoresExpireEndedRooms(t *testing.T) {
+	tests := []struct {
+		name  string
+		store func(t *testing.T) Store
+	}{
+		{"memory", func(*testing.T) Store { return NewMemory(DefaultMaxMessages) }},
+		{"file", func(t *testing.T) Store { return NewFile(tempDir(t), DefaultMaxMessages) }},
+	}
+
+	now := time.Now()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := test.store(t)
+			for _, room := range []string{"ended", "reopened", "running", "recent"} {
+				_ = store.Append(room, message(room))
+			}
+
+			for _, room := range []string{"ended", "reopened"} {
+				if err := store.End(room, now.Add(-2*time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_ = store.End("recent", now)
+			_ = store.Reopen("reopened")
+			// A room without history is not kept as ended
+			_ = store.End("empty", now.Add(-2*time.Hour))
+
+			expired, err := store.Expire(now.Add(-time.Hour))
+			if err != nil || expired != 1 {
+				t.Fatalf("expected one room to expire, got %d, %v", expired, err)
+			}
+			for room, length := range map[string]int{"ended": 0, "reopened": 1, "running": 1, "recent": 1} {
+				if history, _ := store.History(room); len(history) != length {
+					t.Fatalf("expected %d messages in %s, got %v", length, room, ids(history))
+				}
+			}
+
+			// A message reopens the room
+			_ = store.Append("recent", message("again"))
+			if expired, _ = store.Expire(now.Add(time.Hour)); expired != 0 {
+				t.Fatalf("expected the reopened room to be kept, %d expired", expired)
+			}
+		})
+	}
+}
+
+func TestFileCompacts(t *testing.T) {
+	root := tempDir(t)
+	store := NewFile(root, 2)
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		if err := store.Append("room", message(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := store.History("room")
+	if got := ids(history); err != nil || len(got) != 2 || got[0] != "4" || got[1] != "5" {
+		t.Fatalf("expected the last two messages, got %v, %v", got, err)
+	}
+
+	// The file was compacted at four lines and holds the messages appended since
+	data, err := ioutil.ReadFile(filepath.Join(root, "room.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Fatalf("expected 3 lines after the compaction, got %d", lines)
+	}
+
+	// A restarted store counts the existing lines
+	store = NewFile(root, 2)
+	_ = store.Append("room", message("6"))
+	if data, _ = ioutil.ReadFile(filepath.Join(root, "room.jsonl")); strings.Count(string(data), "\n") != 2 {
+		t.Fatalf("expected the restarted store to compact, got %q", data)
+	}
+}
@@ -0,0 +1,261 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	historyExt = ".jsonl"
+	// endedExt is the marker of an ended room, it holds the time the room ended
+	endedExt = ".ended"
+)
+
+// File appends the messages of each room as JSON lines to <root>/<room>.jsonl, the history survives restarts.
+// The file of a room is compacted to the last maxMessages once it holds twice as many lines.
+type File struct {
+	mux         sync.Mutex
+	root        string
+	maxMessages int
+	// lines counts the lines of the files appended to since the start
+	lines map[string]int
+}
+
+func NewFile(root string, maxMessages int) *File {
+	return &File{
+		root:        root,
+		maxMessages: maxMessages,
+		lines:       make(map[string]int),
+	}
+}
+
+func (f *File) Append(room string, message Message) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("unable to marshal chat message: %w", err)
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if err = os.MkdirAll(f.root, 0755); err != nil {
+		return fmt.Errorf("unable to create chat directory: %w", err)
+	}
+
+	lines, counted := f.lines[room]
+	if !counted {
+		messages, err := f.read(room)
+		if err != nil {
+			return err
+		}
+		lines = len(messages)
+	}
+
+	file, err := os.OpenFile(f.path(room), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open chat file: %w", err)
+	}
+	_, err = file.Write(append(line, '\n'))
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("unable to write chat message: %w", err)
+	}
+	f.lines[room] = lines + 1
+
+	if err = f.reopen(room); err != nil {
+		return err
+	}
+	if f.maxMessages > 0 && f.lines[room] >= 2*f.maxMessages {
+		return f.compact(room)
+	}
+	return nil
+}
+
+func (f *File) History(room string) ([]Message, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	messages, err := f.read(room)
+	if err != nil {
+		return nil, err
+	}
+	if f.maxMessages > 0 && len(messages) > f.maxMessages {
+		messages = messages[len(messages)-f.maxMessages:]
+	}
+	return messages, nil
+}
+
+func (f *File) Delete(room string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return f.delete(room)
+}
+
+// End writes the marker of the room, a room without history has nothing to expire
+func (f *File) End(room string, at time.Time) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if _, err := os.Stat(f.path(room)); os.IsNotExist(err) {
+		return nil
+	}
+	if err := ioutil.WriteFile(f.endedPath(room), []byte(at.UTC().Format(time.RFC3339Nano)), 0644); err != nil {
+		return fmt.Errorf("unable to mark the end of the chat: %w", err)
+	}
+	return nil
+}
+
+func (f *File) Reopen(room string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return f.reopen(room)
+}
+
+// Expire reads the markers of the ended rooms, a marker which can not be read is expired by its modification time
+func (f *File) Expire(deadline time.Time) (int, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	markers, err := filepath.Glob(filepath.Join(f.root, "*"+endedExt))
+	if err != nil {
+		return 0, fmt.Errorf("unable to list ended chats: %w", err)
+	}
+
+	expired := 0
+	for _, marker := range markers {
+		endedAt, err := readEnded(marker)
+		if err != nil {
+			return expired, err
+		}
+		if !endedAt.Before(deadline) {
+			continue
+		}
+
+		room, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(marker), endedExt))
+		if err != nil {
+			return expired, fmt.Errorf("unexpected chat marker %s: %w", marker, err)
+		}
+		if err = f.delete(room); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+func readEnded(marker string) (time.Time, error) {
+	data, err := ioutil.ReadFile(marker)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to read chat marker: %w", err)
+	}
+	if endedAt, err := time.Parse(time.RFC3339Nano, string(data)); err == nil {
+		return endedAt, nil
+	}
+
+	info, err := os.Stat(marker)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to read chat marker: %w", err)
+	}
+	return info.ModTime(), nil
+}
+
+// read has to be called with the lock held
+func (f *File) read(room string) ([]Message, error) {
+	messages := make([]Message, 0)
+
+	file, err := os.Open(f.path(room))
+	if os.IsNotExist(err) {
+		return messages, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open chat file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var message Message
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			// A line cut by a crash is skipped, the following ones are still valid
+			continue
+		}
+		messages = append(messages, message)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read chat file: %w", err)
+	}
+
+	return messages, nil
+}
+
+// compact rewrites the file of the room with its last maxMessages, the rename replaces it at once
+func (f *File) compact(room string) error {
+	messages, err := f.read(room)
+	if err != nil {
+		return err
+	}
+	if len(messages) > f.maxMessages {
+		messages = messages[len(messages)-f.maxMessages:]
+	}
+
+	temp, err := ioutil.TempFile(f.root, "compact-*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to compact chat file: %w", err)
+	}
+	writer := bufio.NewWriter(temp)
+	for _, message := range messages {
+		line, _ := json.Marshal(message)
+		_, _ = writer.Write(append(line, '\n'))
+	}
+	err = writer.Flush()
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), f.path(room))
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return fmt.Errorf("unable to compact chat file: %w", err)
+	}
+
+	f.lines[room] = len(messages)
+	return nil
+}
+
+// delete has to be called with the lock held, the marker goes last so a failed delete is expired again
+func (f *File) delete(room string) error {
+	if err := os.Remove(f.path(room)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to delete chat file: %w", err)
+	}
+	delete(f.lines, room)
+	return f.reopen(room)
+}
+
+// reopen has to be called with the lock held
+func (f *File) reopen(room string) error {
+	if err := os.Remove(f.endedPath(room)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to reopen chat: %w", err)
+	}
+	return nil
+}
+
+// path escapes the room, so it can not leave the root directory
+func (f *File) path(room string) string {
+	return filepath.Join(f.root, url.PathEscape(room)+historyExt)
+}
+
+func (f *File) endedPath(room string) string {
+	return filepath.Join(f.root, url.PathEscape(room)+endedExt)
+}
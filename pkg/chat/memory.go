@@ -0,0 +1,83 @@
+package chat
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory keeps the last messages of every room in memory, the history is lost on restart
+type Memory struct {
+	mux         sync.RWMutex
+	maxMessages int
+	rooms       map[string][]Message
+	// ended are the rooms without participants by the time they ended
+	ended map[string]time.Time
+}
+
+func NewMemory(maxMessages int) *Memory {
+	return &Memory{
+		maxMessages: maxMessages,
+		rooms:       make(map[string][]Message),
+		ended:       make(map[string]time.Time),
+	}
+}
+
+func (m *Memory) Append(room string, message Message) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	messages := append(m.rooms[room], message)
+	if m.maxMessages > 0 && len(messages) > m.maxMessages {
+		messages = append([]Message(nil), messages[len(messages)-m.maxMessages:]...)
+	}
+	m.rooms[room] = messages
+	delete(m.ended, room)
+	return nil
+}
+
+func (m *Memory) History(room string) ([]Message, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	return append(make([]Message, 0, len(m.rooms[room])), m.rooms[room]...), nil
+}
+
+func (m *Memory) Delete(room string) error {
+	m.mux.Lock()
+	delete(m.rooms, room)
+	delete(m.ended, room)
+	m.mux.Unlock()
+	return nil
+}
+
+// End marks the room, a room without history has nothing to expire
+func (m *Memory) End(room string, at time.Time) error {
+	m.mux.Lock()
+	if _, ok := m.rooms[room]; ok {
+		m.ended[room] = at
+	}
+	m.mux.Unlock()
+	return nil
+}
+
+func (m *Memory) Reopen(room string) error {
+	m.mux.Lock()
+	delete(m.ended, room)
+	m.mux.Unlock()
+	return nil
+}
+
+func (m *Memory) Expire(deadline time.Time) (int, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	expired := 0
+	for room, endedAt := range m.ended {
+		if endedAt.Before(deadline) {
+			delete(m.rooms, room)
+			delete(m.ended, room)
+			expired++
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,49 @@
+package egress
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// sdpMedia describes one m= section of the generated SDP
+type sdpMedia struct {
+	kind        webrtc.RTPCodecType
+	port        int
+	payloadType uint8
+	codec       *webrtc.RTPCodec
+}
+
+// buildSDP generates a receive description which ffmpeg and GStreamer (sdpdemux) can consume directly
+func buildSDP(clientID string, host string, medias []sdpMedia) string {
+	addrType := "IP4"
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		addrType = "IP6"
+	}
+
+	var sdp strings.Builder
+	sdp.WriteString("v=0\r\n")
+	fmt.Fprintf(&sdp, "o=- 0 0 IN %s %s\r\n", addrType, host)
+	fmt.Fprintf(&sdp, "s=pion-conference %s\r\n", clientID)
+	fmt.Fprintf(&sdp, "c=IN %s %s\r\n", addrType, host)
+	sdp.WriteString("t=0 0\r\n")
+
+	for _, media := range medias {
+		fmt.Fprintf(&sdp, "m=%s %d RTP/AVP %d\r\n", media.kind.String(), media.port, media.payloadType)
+
+		rtpmap := fmt.Sprintf("%s/%d", media.codec.Name, media.codec.ClockRate)
+		if media.codec.Channels > 1 {
+			rtpmap = fmt.Sprintf("%s/%d", rtpmap, media.codec.Channels)
+		}
+		fmt.Fprintf(&sdp, "a=rtpmap:%d %s\r\n", media.payloadType, rtpmap)
+
+		if media.codec.SDPFmtpLine != "" {
+			fmt.Fprintf(&sdp, "a=fmtp:%d %s\r\n", media.payloadType, media.codec.SDPFmtpLine)
+		}
+		sdp.WriteString("a=recvonly\r\n")
+	}
+
+	return sdp.String()
+}
@@ -0,0 +1,167 @@
+// Package egress forwards the tracks of a participant out of the SFU as plain RTP over UDP.
+package egress
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+var ErrSessionClosed = errors.New("egress session is closed")
+
+// Session sends the first video and the first audio track of a participant to host:port and host:port+2,
+// the odd ports are left for RTCP as receivers expect
+type Session struct {
+	mux sync.Mutex
+
+	id       string
+	clientID string
+	host     string
+	port     int
+	sdp      string
+	closed   bool
+	onClose  func()
+
+	conns map[uint32]*net.UDPConn
+}
+
+func NewSession(clientID string, host string, port int, tracks []*webrtc.Track, onClose func()) (*Session, error) {
+	if port <= 0 || port+2 > 65535 {
+		return nil, fmt.Errorf("invalid egress port: %d", port)
+	}
+
+	session := &Session{
+		id:       uuid.New().String(),
+		clientID: clientID,
+		host:     host,
+		port:     port,
+		onClose:  onClose,
+		conns:    make(map[uint32]*net.UDPConn),
+	}
+
+	var (
+		medias = make([]sdpMedia, 0, 2)
+		ports  = map[webrtc.RTPCodecType]int{
+			webrtc.RTPCodecTypeVideo: port,
+			webrtc.RTPCodecTypeAudio: port + 2,
+		}
+	)
+	for _, track := range tracks {
+		kindPort, ok := ports[track.Kind()]
+		if !ok || track.Codec() == nil {
+			continue
+		}
+		delete(ports, track.Kind())
+
+		conn, err := dial(host, kindPort)
+		if err != nil {
+			session.closeConns()
+			return nil, err
+		}
+		session.conns[track.SSRC()] = conn
+
+		medias = append(medias, sdpMedia{
+			kind:        track.Kind(),
+			port:        kindPort,
+			payloadType: track.PayloadType(),
+			codec:       track.Codec(),
+		})
+	}
+
+	if len(medias) == 0 {
+		return nil, fmt.Errorf("[%s] participant has no tracks to egress", clientID)
+	}
+	session.sdp = buildSDP(clientID, host, medias)
+
+	return session, nil
+}
+
+func (s *Session) ID() string {
+	return s.id
+}
+
+func (s *Session) ClientID() string {
+	return s.clientID
+}
+
+func (s *Session) Host() string {
+	return s.host
+}
+
+func (s *Session) Port() int {
+	return s.port
+}
+
+// SDP describes the stream for the receiving side, e.g. ffmpeg -protocol_whitelist file,udp,rtp -i egress.sdp
+func (s *Session) SDP() string {
+	return s.sdp
+}
+
+func (s *Session) WriteRTP(remote *webrtc.Track, packet *rtp.Packet) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.closed {
+		return ErrSessionClosed
+	}
+
+	conn, ok := s.conns[packet.SSRC]
+	if !ok {
+		return nil
+	}
+
+	data, err := packet.Marshal()
+	if err != nil {
+		return fmt.Errorf("unable to marshal egress packet: %w", err)
+	}
+
+	if _, err = conn.Write(data); err != nil {
+		return fmt.Errorf("unable to write egress packet: %w", err)
+	}
+	return nil
+}
+
+func (s *Session) Close() error {
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.closeConns()
+	s.mux.Unlock()
+
+	if s.onClose != nil {
+		s.onClose()
+	}
+	return err
+}
+
+func (s *Session) closeConns() (err error) {
+	for ssrc, conn := range s.conns {
+		if closeErr := conn.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		delete(s.conns, ssrc)
+	}
+	return
+}
+
+func dial(host string, port int) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve egress address: %w", err)
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to dial egress address: %w", err)
+	}
+	return conn, nil
+}
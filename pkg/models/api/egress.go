@@ -0,0 +1,17 @@
+package api
+
+type (
+	EgressStart struct {
+		ClientId string `json:"clientId"`
+		Host     string `json:"host"`
+		Port     int    `json:"port"`
+	}
+
+	EgressSession struct {
+		Id       string `json:"id"`
+		ClientId string `json:"clientId"`
+		Host     string `json:"host"`
+		Port     int    `json:"port"`
+		SDP      string `json:"sdp"`
+	}
+)
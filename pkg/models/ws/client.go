@@ -17,13 +17,15 @@ type Client struct {
 	metadata string
 	err      error
 	mux      sync.RWMutex
+	// writeMux serializes the writes, messages are emitted from the goroutines of other participants and the room
+	writeMux sync.Mutex
 }
 
-func NewClientWithID(conn *websocket.Conn, id string) Client {
+func NewClientWithID(conn *websocket.Conn, id string) *Client {
 	if id == "" {
 		id = uuid.New().String()
 	}
-	return Client{
+	return &Client{
 		id:       id,
 		conn:     conn,
 		metadata: "ivan",
@@ -60,6 +62,9 @@ func (c *Client) Listen() <-chan Message {
 }
 
 func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
 	return c.conn.Close()
 }
 
@@ -71,7 +76,10 @@ func (c *Client) Err() error {
 
 func (c *Client) SetTimeouts(timeout time.Time) error {
 	var err error
-	if err = c.conn.SetWriteDeadline(timeout); err != nil {
+	c.writeMux.Lock()
+	err = c.conn.SetWriteDeadline(timeout)
+	c.writeMux.Unlock()
+	if err != nil {
 		return err
 	}
 
@@ -82,7 +90,14 @@ func (c *Client) SetTimeouts(timeout time.Time) error {
 	return nil
 }
 
+// Write sends the message to the websocket, clients without a connection (e.g. bots) ignore messages
 func (c *Client) Write(msg Message) error {
+	if c.conn == nil {
+		return nil
+	}
+
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
 	return c.conn.WriteJSON(msg)
 }
 
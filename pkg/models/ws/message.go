@@ -1,15 +1,28 @@
 package ws
 
+import "encoding/base64"
+
 const (
 	MessageTypeRoomJoin  string = "ws_room_join"
 	MessageTypeRoomLeave string = "ws_room_leave"
+	// MessageTypeData carries data channel messages for clients without an open data channel
+	MessageTypeData string = "data"
 )
 
-type Message struct {
-	Type    string      `json:"type"`
-	Room    string      `json:"room"`
-	Payload interface{} `json:"payload"`
-}
+type (
+	Message struct {
+		Type    string      `json:"type"`
+		Room    string      `json:"room"`
+		Payload interface{} `json:"payload"`
+	}
+
+	// DataPayload is a data channel message sent over the websocket, binary data is base64 encoded
+	DataPayload struct {
+		Label  string `json:"label"`
+		Data   string `json:"data"`
+		Binary bool   `json:"binary,omitempty"`
+	}
+)
 
 func NewMessage(typ string, room string, payload interface{}) Message {
 	return Message{Type: typ, Room: room, Payload: payload}
@@ -21,3 +34,17 @@ func NewMessageRoomJoin(room string, clientID string, metadata string) Message {
 		"metadata": metadata,
 	})
 }
+
+func NewMessageRoomLeave(room string, clientID string) Message {
+	return NewMessage(MessageTypeRoomLeave, room, map[string]string{
+		"clientID": clientID,
+	})
+}
+
+func NewMessageData(room string, label string, data []byte, binary bool) Message {
+	payload := DataPayload{Label: label, Data: string(data), Binary: binary}
+	if binary {
+		payload.Data = base64.StdEncoding.EncodeToString(data)
+	}
+	return NewMessage(MessageTypeData, room, payload)
+}
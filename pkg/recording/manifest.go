@@ -0,0 +1,26 @@
+package recording
+
+import "time"
+
+type (
+	Manifest struct {
+		Room      string      `json:"room"`
+		Location  string      `json:"location"`
+		StartedAt time.Time   `json:"startedAt"`
+		StoppedAt time.Time   `json:"stoppedAt"`
+		Files     []FileEntry `json:"files"`
+		// Failures are the participants whose recording stopped early, with the reason
+		Failures map[string]string `json:"failures,omitempty"`
+	}
+
+	// FileEntry describes one recorded file stored under Manifest.Location,
+	// StartOffset is relative to Manifest.StartedAt
+	FileEntry struct {
+		Participant string `json:"participant"`
+		TrackID     string `json:"trackId"`
+		Kind        string `json:"kind"`
+		Codec       string `json:"codec"`
+		File        string `json:"file"`
+		StartOffset int64  `json:"startOffsetMs"`
+	}
+)
@@ -0,0 +1,161 @@
+package recording
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+const (
+	opusSampleRate   = 48000
+	opusChannelCount = 2
+)
+
+// ParticipantRecorder writes the tracks of one participant into per-track files
+type ParticipantRecorder struct {
+	mux sync.Mutex
+
+	session  *Session
+	clientID string
+	closed   bool
+
+	writers map[string]media.Writer
+	skipped map[string]struct{}
+}
+
+func newParticipantRecorder(session *Session, clientID string) *ParticipantRecorder {
+	return &ParticipantRecorder{
+		session:  session,
+		clientID: clientID,
+		writers:  make(map[string]media.Writer),
+		skipped:  make(map[string]struct{}),
+	}
+}
+
+func (p *ParticipantRecorder) WriteRTP(remote *webrtc.Track, packet *rtp.Packet) error {
+	err := p.writeRTP(remote, packet)
+	if err != nil && !errors.Is(err, ErrSessionClosed) {
+		p.session.fail(p.clientID, err)
+	}
+	return err
+}
+
+func (p *ParticipantRecorder) writeRTP(remote *webrtc.Track, packet *rtp.Packet) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.closed {
+		return ErrSessionClosed
+	}
+
+	trackID := trackKey(remote)
+	if _, ok := p.skipped[trackID]; ok {
+		return nil
+	}
+
+	writer, ok := p.writers[trackID]
+	if !ok {
+		var err error
+		if writer, err = p.openWriter(trackID, remote); err != nil || writer == nil {
+			p.skipped[trackID] = struct{}{}
+			return err
+		}
+		p.writers[trackID] = writer
+	}
+
+	return writer.WriteRTP(packet)
+}
+
+// Close finishes the files, they are closed without the lock because closing may upload the rest of a file
+func (p *ParticipantRecorder) Close() error {
+	p.mux.Lock()
+	if p.closed {
+		p.mux.Unlock()
+		return nil
+	}
+	p.closed = true
+	writers := p.writers
+	p.writers = nil
+	p.mux.Unlock()
+
+	var closeErr error
+	for trackID, writer := range writers {
+		if err := writer.Close(); err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("unable to close %s track file: %w", trackID, err)
+		}
+	}
+
+	return closeErr
+}
+
+func (p *ParticipantRecorder) isClosed() bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.closed
+}
+
+// openWriter creates the file of a track, unsupported tracks are not a failure of the participant recording and get no writer
+func (p *ParticipantRecorder) openWriter(trackID string, remote *webrtc.Track) (media.Writer, error) {
+	codec := remote.Codec()
+	if codec == nil {
+		log.Printf("[%s] track %s has no negotiated codec, skip recording it", p.clientID, trackID)
+		return nil, nil
+	}
+
+	var extension string
+	switch {
+	case strings.EqualFold(codec.Name, webrtc.VP8):
+		extension = "ivf"
+	case strings.EqualFold(codec.Name, webrtc.Opus):
+		extension = "ogg"
+	default:
+		log.Printf("[%s] recording of %s tracks is not supported, skip track %s", p.clientID, codec.Name, trackID)
+		return nil, nil
+	}
+
+	kind := remote.Kind().String()
+	fileName := fmt.Sprintf("%s-%s-%s.%s", sanitizeName(p.clientID), kind, sanitizeName(trackID), extension)
+
+	file, err := p.session.create(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var writer media.Writer
+	if extension == "ivf" {
+		writer, err = ivfwriter.NewWith(file)
+	} else {
+		writer, err = oggwriter.NewWith(file, opusSampleRate, opusChannelCount)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("unable to create %s writer: %w", extension, err)
+	}
+
+	p.session.addFile(FileEntry{
+		Participant: p.clientID,
+		TrackID:     trackID,
+		Kind:        kind,
+		Codec:       codec.Name,
+		File:        fileName,
+		StartOffset: p.session.offset().Milliseconds(),
+	})
+
+	return writer, nil
+}
+
+func trackKey(track *webrtc.Track) string {
+	if id := track.ID(); id != "" {
+		return id
+	}
+	return strconv.FormatUint(uint64(track.SSRC()), 10)
+}
@@ -0,0 +1,242 @@
+package recording
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"pion-conference/pkg/recording/storage"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+const manifestFileName = storage.ManifestName
+
+// Mode defines how the tracks of a participant are stored
+type Mode string
+
+const (
+	// ModeTracks writes every track into its own IVF (VP8) or Ogg (Opus) file
+	ModeTracks Mode = "tracks"
+	// ModeWebM muxes the audio and the video of a participant into one WebM file
+	ModeWebM Mode = "webm"
+)
+
+var ErrSessionClosed = errors.New("recording session is closed")
+
+// ParticipantSink receives the RTP of one participant, it matches webrtc.TrackSink
+type ParticipantSink interface {
+	WriteRTP(remote *webrtc.Track, packet *rtp.Packet) error
+	Close() error
+
+	isClosed() bool
+}
+
+// Session records every participant of a room into its own directory
+type Session struct {
+	mux sync.Mutex
+
+	room      string
+	store     storage.Storage
+	prefix    string
+	mode      Mode
+	startedAt time.Time
+	closed    bool
+
+	participants map[string]ParticipantSink
+	files        []FileEntry
+	failures     map[string]string
+	onFailure    func(clientID string, err error)
+}
+
+func ParseMode(value string) (Mode, error) {
+	switch Mode(value) {
+	case "", ModeTracks:
+		return ModeTracks, nil
+	case ModeWebM:
+		return ModeWebM, nil
+	}
+	return "", fmt.Errorf("unknown recording mode: %s", value)
+}
+
+func NewSession(store storage.Storage, room string, mode Mode) (*Session, error) {
+	if store == nil {
+		return nil, errors.New("recording storage is not configured")
+	}
+
+	startedAt := time.Now()
+
+	return &Session{
+		room:         room,
+		store:        store,
+		prefix:       path.Join(sanitizeName(room), startedAt.UTC().Format("20060102T150405Z")),
+		mode:         mode,
+		startedAt:    startedAt,
+		participants: make(map[string]ParticipantSink),
+		failures:     make(map[string]string),
+	}, nil
+}
+
+func (s *Session) Room() string {
+	return s.room
+}
+
+// Prefix is the storage location of the session files
+func (s *Session) Prefix() string {
+	return s.prefix
+}
+
+func (s *Session) StartedAt() time.Time {
+	return s.startedAt
+}
+
+func (s *Session) Mode() Mode {
+	return s.mode
+}
+
+// Participant returns the recorder which should be attached to the participant Connector.
+// Recorders add their files to the session with their own lock held, so they are not asked under the session lock.
+func (s *Session) Participant(clientID string) ParticipantSink {
+	s.mux.Lock()
+	recorder, ok := s.participants[clientID]
+	s.mux.Unlock()
+	if ok && !recorder.isClosed() {
+		return recorder
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	// Another call may have replaced the closed recorder in the meantime
+	if current, ok := s.participants[clientID]; ok && current != recorder {
+		return current
+	}
+	if s.mode == ModeWebM {
+		recorder = newWebMRecorder(s, clientID)
+	} else {
+		recorder = newParticipantRecorder(s, clientID)
+	}
+	s.participants[clientID] = recorder
+	return recorder
+}
+
+// OnFailure sets the handler which is told once when the recording of a participant fails, e.g. on ErrUploadBacklog.
+// The connector stops writing to the recorder after the failure.
+func (s *Session) OnFailure(handler func(clientID string, err error)) {
+	s.mux.Lock()
+	s.onFailure = handler
+	s.mux.Unlock()
+}
+
+// fail records the first failure of a participant recording, it is called without the lock of the recorder
+func (s *Session) fail(clientID string, err error) {
+	s.mux.Lock()
+	if _, ok := s.failures[clientID]; ok {
+		s.mux.Unlock()
+		return
+	}
+	s.failures[clientID] = err.Error()
+	handler := s.onFailure
+	s.mux.Unlock()
+
+	if handler != nil {
+		handler(clientID, err)
+	}
+}
+
+// Close finalizes all opened files and writes the manifest of the session
+func (s *Session) Close() (*Manifest, error) {
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return nil, ErrSessionClosed
+	}
+	s.closed = true
+	participants := s.participants
+	s.participants = make(map[string]ParticipantSink)
+	s.mux.Unlock()
+
+	var closeErr error
+	for clientID, recorder := range participants {
+		if err := recorder.Close(); err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("unable to close recorder of %s: %w", clientID, err)
+		}
+	}
+
+	s.mux.Lock()
+	manifest := &Manifest{
+		Room:      s.room,
+		Location:  s.prefix,
+		StartedAt: s.startedAt,
+		StoppedAt: time.Now(),
+		Files:     append([]FileEntry{}, s.files...),
+	}
+	if len(s.failures) != 0 {
+		manifest.Failures = make(map[string]string, len(s.failures))
+		for clientID, reason := range s.failures {
+			manifest.Failures[clientID] = reason
+		}
+	}
+	s.mux.Unlock()
+
+	if err := s.writeManifest(manifest); err != nil {
+		return manifest, err
+	}
+
+	return manifest, closeErr
+}
+
+func (s *Session) writeManifest(manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal recording manifest: %w", err)
+	}
+
+	writer, err := s.create(manifestFileName)
+	if err != nil {
+		return err
+	}
+
+	if _, err = writer.Write(data); err != nil {
+		_ = writer.Close()
+		return fmt.Errorf("unable to write recording manifest: %w", err)
+	}
+	if err = writer.Close(); err != nil {
+		return fmt.Errorf("unable to write recording manifest: %w", err)
+	}
+	return nil
+}
+
+func (s *Session) create(fileName string) (io.WriteCloser, error) {
+	writer, err := s.store.Create(path.Join(s.prefix, fileName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create recording file %s: %w", fileName, err)
+	}
+	return writer, nil
+}
+
+func (s *Session) addFile(entry FileEntry) {
+	s.mux.Lock()
+	s.files = append(s.files, entry)
+	s.mux.Unlock()
+}
+
+func (s *Session) offset() time.Duration {
+	return time.Since(s.startedAt)
+}
+
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+}
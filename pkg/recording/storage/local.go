@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores recordings on the local filesystem under the root directory
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+func (l *Local) Create(name string) (io.WriteCloser, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create recording directory: %w", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create recording file: %w", err)
+	}
+	return file, nil
+}
+
+func (l *Local) List(prefix string) ([]Object, error) {
+	objects := make([]Object, 0)
+
+	err := filepath.Walk(l.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == l.root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		objects = append(objects, Object{
+			Name:    name,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list recordings: %w", err)
+	}
+
+	return objects, nil
+}
+
+func (l *Local) Delete(name string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("unable to delete recording file: %w", err)
+	}
+
+	// Drop the directories left empty, the root itself is kept
+	for dir := filepath.Dir(path); dir != filepath.Clean(l.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (l *Local) path(name string) (string, error) {
+	clean := filepath.Clean("/" + filepath.FromSlash(name))
+	if clean == string(filepath.Separator) {
+		return "", fmt.Errorf("invalid recording name: %q", name)
+	}
+	return filepath.Join(l.root, clean), nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"errors"
+	"log"
+	"path"
+	"sort"
+	"time"
+)
+
+// Retention deletes the recording sessions which ended more than MaxAge ago
+type Retention struct {
+	Storage  Storage
+	MaxAge   time.Duration
+	Interval time.Duration
+}
+
+// Run applies the retention every Interval until stop is closed
+func (r Retention) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if deleted, err := r.Apply(time.Now()); err != nil {
+			log.Printf("recordings retention error: %s", err)
+		} else if deleted != 0 {
+			log.Printf("recordings retention deleted %d objects", deleted)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Apply deletes the sessions whose manifest is older than MaxAge. A session without a manifest is still being
+// recorded or was never finished, it is deleted once nothing was written to it for MaxAge.
+// The manifest of a session is deleted last, a run which fails in between deletes the rest of it the next time.
+func (r Retention) Apply(now time.Time) (int, error) {
+	objects, err := r.Storage.List("")
+	if err != nil {
+		return 0, err
+	}
+
+	sessions := make(map[string][]Object)
+	for _, object := range objects {
+		prefix := path.Dir(object.Name)
+		sessions[prefix] = append(sessions[prefix], object)
+	}
+
+	deadline := now.Add(-r.MaxAge)
+	deleted := 0
+	for _, session := range sessions {
+		if !sessionExpired(session, deadline) {
+			continue
+		}
+
+		sort.SliceStable(session, func(i, j int) bool {
+			return !isManifest(session[i]) && isManifest(session[j])
+		})
+		for _, object := range session {
+			if err = r.Storage.Delete(object.Name); err != nil && !errors.Is(err, ErrNotFound) {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func sessionExpired(session []Object, deadline time.Time) bool {
+	var latest time.Time
+	for _, object := range session {
+		if isManifest(object) {
+			return object.ModTime.Before(deadline)
+		}
+		if object.ModTime.After(latest) {
+			latest = object.ModTime
+		}
+	}
+	return latest.Before(deadline)
+}
+
+func isManifest(object Object) bool {
+	return path.Base(object.Name) == ManifestName
+}
@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MinPartSize is the smallest part S3 accepts for every part but the last one
+	MinPartSize = 5 << 20
+	// DefaultMaxPendingParts is how many full parts of a recording may wait for their upload
+	DefaultMaxPendingParts = 4
+)
+
+// ErrUploadBacklog fails a recording whose parts are not uploaded as fast as they are recorded
+var ErrUploadBacklog = errors.New("s3 upload is too slow")
+
+type (
+	S3Config struct {
+		// Endpoint is the base URL of the service, e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+		Endpoint  string
+		Region    string
+		Bucket    string
+		AccessKey string
+		SecretKey string
+		PartSize  int
+		// MaxPendingParts bounds the memory of a recording when the uploads are slower than the recording
+		MaxPendingParts int
+	}
+
+	// S3 stores recordings in an S3 compatible bucket using path-style requests, so it works with MinIO as well
+	S3 struct {
+		config   S3Config
+		endpoint *url.URL
+		client   *http.Client
+	}
+
+	s3Part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+
+	s3InitiateResult struct {
+		UploadID string `xml:"UploadId"`
+	}
+
+	s3CompleteRequest struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}
+
+	s3ListResult struct {
+		Contents []struct {
+			Key          string    `xml:"Key"`
+			LastModified time.Time `xml:"LastModified"`
+			Size         int64     `xml:"Size"`
+		} `xml:"Contents"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+
+	s3Error struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+)
+
+func NewS3(config S3Config) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint: %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("s3 bucket is not configured")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PartSize < MinPartSize {
+		config.PartSize = MinPartSize
+	}
+	if config.MaxPendingParts <= 0 {
+		config.MaxPendingParts = DefaultMaxPendingParts
+	}
+
+	return &S3{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+func (s *S3) Create(name string) (io.WriteCloser, error) {
+	return &s3Writer{
+		storage: s,
+		key:     strings.TrimLeft(name, "/"),
+		buffer:  bytes.NewBuffer(make([]byte, 0, s.config.PartSize)),
+	}, nil
+}
+
+func (s *S3) List(prefix string) ([]Object, error) {
+	objects := make([]Object, 0)
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		body, _, err := s.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list s3 objects: %w", err)
+		}
+
+		var result s3ListResult
+		if err = xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("unable to parse s3 list response: %w", err)
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, Object{
+				Name:    content.Key,
+				Size:    content.Size,
+				ModTime: content.LastModified,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) Delete(name string) error {
+	if _, _, err := s.do(http.MethodDelete, name, nil, nil); err != nil {
+		return fmt.Errorf("unable to delete s3 object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3) do(method string, key string, query url.Values, payload []byte) ([]byte, http.Header, error) {
+	target := *s.endpoint
+	target.Path = target.Path + "/" + s.config.Bucket
+	target.RawPath = target.EscapedPath()
+	if key != "" {
+		segments := strings.Split(strings.TrimLeft(key, "/"), "/")
+		encoded := make([]string, len(segments))
+		for i, segment := range segments {
+			encoded[i] = uriEncode(segment)
+		}
+		target.Path += "/" + strings.TrimLeft(key, "/")
+		target.RawPath += "/" + strings.Join(encoded, "/")
+	}
+	target.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.ContentLength = int64(len(payload))
+	signV4(req, payload, s.config.Region, s.config.AccessKey, s.config.SecretKey, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound && method != http.MethodPost {
+		return nil, nil, ErrNotFound
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		var s3Err s3Error
+		if xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != "" {
+			return nil, nil, fmt.Errorf("s3 %s: %s", s3Err.Code, s3Err.Message)
+		}
+		return nil, nil, fmt.Errorf("s3 responded with status %d", resp.StatusCode)
+	}
+
+	return body, resp.Header, nil
+}
+
+// s3Writer buffers data into parts of PartSize, small recordings are stored with a single PUT.
+// Recorders write from the RTP path, so the parts are uploaded by upload in the background
+// and Write fails with ErrUploadBacklog instead of waiting when MaxPendingParts are queued.
+type s3Writer struct {
+	storage *S3
+	key     string
+	buffer  *bytes.Buffer
+	closed  bool
+
+	parts chan []byte
+	done  chan struct{}
+
+	errMux sync.Mutex
+	err    error
+
+	// uploadID and uploaded are owned by upload until done is closed
+	uploadID string
+	uploaded []s3Part
+}
+
+func (w *s3Writer) Write(data []byte) (int, error) {
+	if err := w.failed(); err != nil {
+		return 0, err
+	}
+	if w.closed {
+		return 0, errors.New("s3 writer is closed")
+	}
+
+	written := 0
+	for len(data) != 0 {
+		free := w.storage.config.PartSize - w.buffer.Len()
+		if free > len(data) {
+			free = len(data)
+		}
+		w.buffer.Write(data[:free])
+		data = data[free:]
+		written += free
+
+		if w.buffer.Len() == w.storage.config.PartSize {
+			if err := w.queuePart(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (w *s3Writer) Close() error {
+	if w.closed {
+		return w.failed()
+	}
+	w.closed = true
+
+	if w.parts == nil {
+		if _, _, err := w.storage.do(http.MethodPut, w.key, nil, w.buffer.Bytes()); err != nil {
+			return fmt.Errorf("unable to put s3 object %s: %w", w.key, err)
+		}
+		return nil
+	}
+
+	if w.failed() == nil && w.buffer.Len() != 0 {
+		// The last part may wait for a free slot, the recording is over
+		w.parts <- w.buffer.Bytes()
+	}
+	close(w.parts)
+	<-w.done
+
+	// upload aborted the upload already
+	if err := w.failed(); err != nil {
+		return err
+	}
+
+	payload, err := xml.Marshal(s3CompleteRequest{Parts: w.uploaded})
+	if err != nil {
+		w.abort()
+		return err
+	}
+
+	query := url.Values{"uploadId": {w.uploadID}}
+	if _, _, err = w.storage.do(http.MethodPost, w.key, query, payload); err != nil {
+		w.abort()
+		return fmt.Errorf("unable to complete s3 upload of %s: %w", w.key, err)
+	}
+	return nil
+}
+
+// queuePart hands the full buffer to upload, which is started with the first part
+func (w *s3Writer) queuePart() error {
+	if w.parts == nil {
+		w.parts = make(chan []byte, w.storage.config.MaxPendingParts)
+		w.done = make(chan struct{})
+		go w.upload()
+	}
+
+	select {
+	case w.parts <- w.buffer.Bytes():
+	default:
+		err := fmt.Errorf("%w: %d parts of %s are pending", ErrUploadBacklog, len(w.parts), w.key)
+		w.fail(err)
+		return err
+	}
+
+	w.buffer = bytes.NewBuffer(make([]byte, 0, w.storage.config.PartSize))
+	return nil
+}
+
+// upload uploads the queued parts in order until Close, the upload is aborted on the first failure
+func (w *s3Writer) upload() {
+	defer close(w.done)
+
+	for part := range w.parts {
+		if w.failed() == nil {
+			if err := w.uploadPart(part); err != nil {
+				w.fail(err)
+			}
+		}
+		if w.failed() != nil {
+			w.abort()
+		}
+	}
+}
+
+func (w *s3Writer) uploadPart(part []byte) error {
+	if w.uploadID == "" {
+		body, _, err := w.storage.do(http.MethodPost, w.key, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return fmt.Errorf("unable to create s3 upload of %s: %w", w.key, err)
+		}
+
+		var result s3InitiateResult
+		if err = xml.Unmarshal(body, &result); err != nil || result.UploadID == "" {
+			return fmt.Errorf("unable to parse s3 upload of %s: %v", w.key, err)
+		}
+		w.uploadID = result.UploadID
+	}
+
+	partNumber := len(w.uploaded) + 1
+	query := url.Values{
+		"partNumber": {strconv.Itoa(partNumber)},
+		"uploadId":   {w.uploadID},
+	}
+
+	_, header, err := w.storage.do(http.MethodPut, w.key, query, part)
+	if err != nil {
+		return fmt.Errorf("unable to upload part %d of %s: %w", partNumber, w.key, err)
+	}
+
+	w.uploaded = append(w.uploaded, s3Part{PartNumber: partNumber, ETag: header.Get("ETag")})
+	return nil
+}
+
+func (w *s3Writer) abort() {
+	if w.uploadID == "" {
+		return
+	}
+
+	if _, _, err := w.storage.do(http.MethodDelete, w.key, url.Values{"uploadId": {w.uploadID}}, nil); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("unable to abort s3 upload of %s: %s", w.key, err)
+	}
+	w.uploadID = ""
+}
+
+// fail keeps the first error of the writer
+func (w *s3Writer) fail(err error) {
+	w.errMux.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.errMux.Unlock()
+}
+
+func (w *s3Writer) failed() error {
+	w.errMux.Lock()
+	defer w.errMux.Unlock()
+	return w.err
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 implements the requests of the S3 storage for the bucket "recordings"
+type fakeS3 struct {
+	mux      sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+	uploads  map[string]map[int][]byte
+	requests []string
+	nextID   int
+
+	// failPart fails the upload of that part number
+	failPart int
+	// partGate blocks the part uploads until it is closed
+	partGate chan struct{}
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3) {
+	fake := &fakeS3{
+		objects:  make(map[string][]byte),
+		modified: make(map[string]time.Time),
+		uploads:  make(map[string]map[int][]byte),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	s3, err := NewS3(S3Config{Endpoint: server.URL, Bucket: "recordings", AccessKey: "key", SecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, s3
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), signAlgorithm) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/recordings"), "/")
+	query := r.URL.Query()
+	_, initiate := query["uploads"]
+	body, _ := ioutil.ReadAll(r.Body)
+
+	if r.Method == http.MethodPut && query.Get("partNumber") != "" && f.partGate != nil {
+		<-f.partGate
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && initiate:
+		f.record("initiate")
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && query.Get("partNumber") != "":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.record("part " + query.Get("partNumber"))
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok || number == f.failPart {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf("\"etag-%d\"", number))
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		f.record("complete")
+		parts, ok := f.uploads[query.Get("uploadId")]
+		var complete s3CompleteRequest
+		if !ok || xml.Unmarshal(body, &complete) != nil || len(complete.Parts) != len(parts) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var object []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf("\"etag-%d\"", i+1) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			object = append(object, parts[part.PartNumber]...)
+		}
+		delete(f.uploads, query.Get("uploadId"))
+		f.store(key, object)
+	case r.Method == http.MethodDelete && query.Get("uploadId") != "":
+		f.record("abort")
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.record("put")
+		f.store(key, body)
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.list(w, query.Get("prefix"))
+	case r.Method == http.MethodDelete:
+		f.record("delete " + key)
+		if _, ok := f.objects[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	fmt.Fprint(w, "<ListBucketResult>")
+	for _, key := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>%s</LastModified><Size>%d</Size></Contents>",
+			key, f.modified[key].Format(time.RFC3339), len(f.objects[key]))
+	}
+	fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+}
+
+func (f *fakeS3) store(key string, object []byte) {
+	f.objects[key] = object
+	f.modified[key] = time.Now().UTC()
+}
+
+func (f *fakeS3) record(request string) {
+	f.requests = append(f.requests, request)
+}
+
+func (f *fakeS3) recorded() string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return strings.Join(f.requests, ", ")
+}
+
+func (f *fakeS3) pendingUploads() int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return len(f.uploads)
+}
+
+func recordingData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+func TestS3SmallObject(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+
+	writer, _ := s3.Create("room/manifest.json")
+	if _, err := writer.Write([]byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unable to close: %s", err)
+	}
+
+	if got := fake.recorded(); got != "put" {
+		t.Fatalf("unexpected requests: %s", got)
+	}
+	if string(fake.objects["room/manifest.json"]) != "{}" {
+		t.Fatalf("unexpected object: %q", fake.objects["room/manifest.json"])
+	}
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	data := recordingData(2*MinPartSize + 1000)
+
+	writer, _ := s3.Create("/room/user-video.ivf")
+	// Small writes like the ones of the muxers
+	for chunk := data; len(chunk) != 0; {
+		n := 1200
+		if n > len(chunk) {
+			n = len(chunk)
+		}
+		if _, err := writer.Write(chunk[:n]); err != nil {
+			t.Fatalf("unable to write: %s", err)
+		}
+		chunk = chunk[n:]
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unable to close: %s", err)
+	}
+
+	if got := fake.recorded(); got != "initiate, part 1, part 2, part 3, complete" {
+		t.Fatalf("unexpected requests: %s", got)
+	}
+	if !bytes.Equal(fake.objects["room/user-video.ivf"], data) {
+		t.Fatal("stored object differs from the written data")
+	}
+}
+
+func TestS3AbortsFailedUpload(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	fake.failPart = 2
+
+	writer, _ := s3.Create("room/user-video.ivf")
+	// The failure of the second part is seen by the writes after it or by Close
+	_, _ = writer.Write(recordingData(2 * MinPartSize))
+	if err := writer.Close(); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+
+	if got := fake.recorded(); got != "initiate, part 1, part 2, abort" {
+		t.Fatalf("unexpected requests: %s", got)
+	}
+	if fake.pendingUploads() != 0 || len(fake.objects) != 0 {
+		t.Fatalf("upload was not aborted: %d uploads, %d objects", fake.pendingUploads(), len(fake.objects))
+	}
+}
+
+func TestS3UploadBacklog(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	fake.partGate = make(chan struct{})
+	s3.config.MaxPendingParts = 1
+
+	writer, _ := s3.Create("room/user-video.ivf")
+	part := recordingData(MinPartSize)
+
+	// Write must not wait for the blocked upload: one part is uploading, one is pending, the third fails
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		_, err = writer.Write(part)
+	}
+	if !errors.Is(err, ErrUploadBacklog) {
+		t.Fatalf("expected ErrUploadBacklog, got %v", err)
+	}
+	if _, err = writer.Write([]byte{1}); !errors.Is(err, ErrUploadBacklog) {
+		t.Fatalf("expected the writer to stay failed, got %v", err)
+	}
+
+	close(fake.partGate)
+	if err = writer.Close(); !errors.Is(err, ErrUploadBacklog) {
+		t.Fatalf("expected ErrUploadBacklog from Close, got %v", err)
+	}
+	if fake.pendingUploads() != 0 || len(fake.objects) != 0 {
+		t.Fatalf("upload was not aborted: %s", fake.recorded())
+	}
+}
+
+func TestS3Retention(t *testing.T) {
+	fake, s3 := newFakeS3(t)
+	now := time.Now().UTC()
+	objects := map[string]time.Duration{
+		// Ended two days ago
+		"room/old/user-video.ivf": 50 * time.Hour,
+		"room/old/manifest.json":  48 * time.Hour,
+		// A long session which ended an hour ago, its first file is older than MaxAge
+		"room/long/user-video.ivf": 30 * time.Hour,
+		"room/long/manifest.json":  time.Hour,
+		// Still recording
+		"room/running/user-video.ivf": time.Hour,
+		// Never finished, nothing was written for two days
+		"room/abandoned/user-video.ivf": 48 * time.Hour,
+	}
+	for name, age := range objects {
+		fake.objects[name] = []byte(name)
+		fake.modified[name] = now.Add(-age)
+	}
+
+	deleted, err := Retention{Storage: s3, MaxAge: 24 * time.Hour}.Apply(now)
+	if err != nil || deleted != 3 {
+		t.Fatalf("deleted %d, err %v", deleted, err)
+	}
+
+	listed, err := s3.List("room/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, object := range listed {
+		names = append(names, object.Name)
+	}
+	sort.Strings(names)
+	expected := []string{"room/long/manifest.json", "room/long/user-video.ivf", "room/running/user-video.ivf"}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v to be kept, got %v", expected, names)
+	}
+
+	// The manifest of a session goes last
+	var deletes []string
+	for _, request := range strings.Split(fake.recorded(), ", ") {
+		if strings.HasPrefix(request, "delete room/old/") {
+			deletes = append(deletes, request)
+		}
+	}
+	if len(deletes) != 2 || !strings.HasSuffix(deletes[1], ManifestName) {
+		t.Fatalf("expected the manifest to be deleted last, got %v", deletes)
+	}
+}
@@ -0,0 +1,29 @@
+// Package storage abstracts the place where recordings are written to.
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// ManifestName is the object a recording session writes last, under the same prefix as its files
+const ManifestName = "manifest.json"
+
+type (
+	Object struct {
+		Name    string    `json:"name"`
+		Size    int64     `json:"size"`
+		ModTime time.Time `json:"modTime"`
+	}
+
+	// Storage keeps recordings under slash separated names, e.g. room/20200710T101010Z/user-video.ivf.
+	// Writers returned by Create may implement io.Seeker, media writers use it to finalize headers.
+	Storage interface {
+		Create(name string) (io.WriteCloser, error)
+		List(prefix string) ([]Object, error)
+		Delete(name string) error
+	}
+)
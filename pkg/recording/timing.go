@@ -0,0 +1,87 @@
+package recording
+
+import "time"
+
+// trackClock converts RTP timestamps of one track into milliseconds from the beginning of the file.
+// The first sample is placed at its arrival time, the following ones follow the RTP clock so the
+// jitter of the network does not end up in the recording.
+type trackClock struct {
+	clockRate int64
+
+	started       bool
+	base          int64
+	lastTimestamp uint32
+	extended      int64
+}
+
+func newTrackClock(clockRate uint32) *trackClock {
+	if clockRate == 0 {
+		clockRate = 90000
+	}
+	return &trackClock{clockRate: int64(clockRate)}
+}
+
+func (c *trackClock) timecode(timestamp uint32, elapsed time.Duration) int64 {
+	if !c.started {
+		c.started = true
+		c.base = elapsed.Milliseconds()
+		c.lastTimestamp = timestamp
+	}
+
+	// int32 difference unwraps the 32 bit RTP timestamp
+	c.extended += int64(int32(timestamp - c.lastTimestamp))
+	c.lastTimestamp = timestamp
+
+	timecode := c.base + c.extended*1000/c.clockRate
+	if timecode < 0 {
+		return 0
+	}
+	return timecode
+}
+
+// gapDetector remembers the missing sequence numbers until they are too old to be reordered
+type gapDetector struct {
+	maxLate uint16
+
+	started bool
+	highest uint16
+	missing map[uint16]struct{}
+}
+
+func newGapDetector(maxLate uint16) *gapDetector {
+	return &gapDetector{
+		maxLate: maxLate,
+		missing: make(map[uint16]struct{}),
+	}
+}
+
+func (g *gapDetector) push(sequenceNumber uint16) {
+	if !g.started {
+		g.started = true
+		g.highest = sequenceNumber
+		return
+	}
+
+	diff := int16(sequenceNumber - g.highest)
+	if diff <= 0 {
+		delete(g.missing, sequenceNumber)
+		return
+	}
+
+	for seq := g.highest + 1; seq != sequenceNumber; seq++ {
+		g.missing[seq] = struct{}{}
+	}
+	g.highest = sequenceNumber
+}
+
+// lost reports whether a packet was not received within maxLate packets
+func (g *gapDetector) lost() bool {
+	lost := false
+	for seq := range g.missing {
+		if g.highest-seq >= g.maxLate {
+			delete(g.missing, seq)
+			lost = true
+		}
+	}
+	return lost
+}
@@ -0,0 +1,123 @@
+package webm
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Matroska element IDs used by the Writer
+const (
+	idEBML               = 0x1A45DFA3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42F7
+	idEBMLMaxIDLength    = 0x42F2
+	idEBMLMaxSizeLength  = 0x42F3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+
+	idSegment       = 0x18538067
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idMuxingApp     = 0x4D80
+	idWritingApp    = 0x5741
+	idDuration      = 0x4489
+
+	idTracks            = 0x1654AE6B
+	idTrackEntry        = 0xAE
+	idTrackNumber       = 0xD7
+	idTrackUID          = 0x73C5
+	idTrackType         = 0x83
+	idCodecID           = 0x86
+	idCodecPrivate      = 0x63A2
+	idVideo             = 0xE0
+	idPixelWidth        = 0xB0
+	idPixelHeight       = 0xBA
+	idAudio             = 0xE1
+	idSamplingFrequency = 0xB5
+	idChannels          = 0x9F
+
+	idCluster     = 0x1F43B675
+	idTimecode    = 0xE7
+	idSimpleBlock = 0xA3
+)
+
+// unknownSize marks a master element which size is not known yet, it always takes 8 bytes
+const unknownSize = 0x01FFFFFFFFFFFFFF
+
+func encodeID(id uint32) []byte {
+	switch {
+	case id >= 0x1000000:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 0x10000:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 0x100:
+		return []byte{byte(id >> 8), byte(id)}
+	}
+	return []byte{byte(id)}
+}
+
+// encodeSize returns the shortest vint representation of size
+func encodeSize(size uint64) []byte {
+	length := 1
+	for length < 8 && size >= (uint64(1)<<(7*uint(length)))-1 {
+		length++
+	}
+	return encodeSizeFixed(size, length)
+}
+
+// encodeSizeFixed returns vint of the given length, it is used for sizes patched after writing
+func encodeSizeFixed(size uint64, length int) []byte {
+	buf := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		buf[i] = byte(size)
+		size >>= 8
+	}
+	buf[0] |= 0x80 >> uint(length-1)
+	return buf
+}
+
+func element(id uint32, data []byte) []byte {
+	buf := encodeID(id)
+	buf = append(buf, encodeSize(uint64(len(data)))...)
+	return append(buf, data...)
+}
+
+func master(id uint32, children ...[]byte) []byte {
+	var data []byte
+	for _, child := range children {
+		data = append(data, child...)
+	}
+	return element(id, data)
+}
+
+func uintElement(id uint32, value uint64) []byte {
+	length := 1
+	for length < 8 && value >= uint64(1)<<(8*uint(length)) {
+		length++
+	}
+
+	data := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		data[i] = byte(value)
+		value >>= 8
+	}
+	return element(id, data)
+}
+
+// fixedUintElement always takes 8 bytes of data, so the value could be patched in place
+func fixedUintElement(id uint32, value uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, value)
+	return element(id, data)
+}
+
+func floatElement(id uint32, value float64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(value))
+	return element(id, data)
+}
+
+func stringElement(id uint32, value string) []byte {
+	return element(id, []byte(value))
+}
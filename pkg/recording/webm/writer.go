@@ -0,0 +1,368 @@
+// Package webm implements a minimal live-friendly WebM (Matroska) muxer.
+//
+// The Writer declares all tracks up front and writes clusters as frames arrive.
+// When the destination is an io.WriteSeeker the element sizes, the duration and
+// the video dimensions are patched in place on Close, otherwise the file stays
+// in the "unknown size" live form which every WebM player accepts.
+package webm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	TrackTypeVideo = 1
+	TrackTypeAudio = 2
+
+	CodecVP8  = "V_VP8"
+	CodecOpus = "A_OPUS"
+
+	// maxClusterDuration keeps the block timecodes inside the int16 range
+	maxClusterDuration = 5000
+	timecodeScale      = 1000000
+	muxingApp          = "pion-conference"
+)
+
+var ErrWriterClosed = errors.New("webm writer is closed")
+
+type (
+	Track struct {
+		Number uint64
+		Type   uint8
+		Codec  string
+
+		// Video
+		Width  uint64
+		Height uint64
+
+		// Audio
+		SampleRate float64
+		Channels   uint64
+	}
+
+	Writer struct {
+		w      io.Writer
+		seeker io.WriteSeeker
+		offset int64
+		closed bool
+
+		tracks []Track
+
+		segmentSizePos   int64
+		segmentDataStart int64
+		durationPos      int64
+		dimensionPos     map[uint64][2]int64
+		dimensionsSet    map[uint64]bool
+
+		clusterOpen      bool
+		clusterSizePos   int64
+		clusterDataStart int64
+		clusterTimecode  int64
+		lastTimecode     int64
+	}
+)
+
+// NewWriter writes the WebM header describing the tracks, Track.Number has to be unique and non-zero
+func NewWriter(w io.Writer, tracks ...Track) (*Writer, error) {
+	if len(tracks) == 0 {
+		return nil, errors.New("webm writer needs at least one track")
+	}
+
+	writer := &Writer{
+		w:             w,
+		tracks:        tracks,
+		dimensionPos:  make(map[uint64][2]int64),
+		dimensionsSet: make(map[uint64]bool),
+	}
+	if seeker, ok := w.(io.WriteSeeker); ok {
+		writer.seeker = seeker
+	}
+
+	if err := writer.writeHeader(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// WriteFrame writes one encoded frame, timecode is in milliseconds from the start of the file
+func (w *Writer) WriteFrame(trackNumber uint64, keyframe bool, timecode int64, frame []byte) error {
+	if w.closed {
+		return ErrWriterClosed
+	}
+	if timecode < w.lastTimecode {
+		// Blocks of the different tracks are interleaved, do not allow timecodes to go back
+		timecode = w.lastTimecode
+	}
+
+	isVideo := w.trackType(trackNumber) == TrackTypeVideo
+	if !w.clusterOpen ||
+		timecode-w.clusterTimecode >= maxClusterDuration ||
+		(keyframe && isVideo && timecode > w.clusterTimecode) {
+		if err := w.startCluster(timecode); err != nil {
+			return err
+		}
+	}
+
+	block := make([]byte, 0, len(frame)+4)
+	block = append(block, encodeSize(trackNumber)...)
+	block = append(block, byte(int16(timecode-w.clusterTimecode)>>8), byte(int16(timecode-w.clusterTimecode)))
+	if keyframe {
+		block = append(block, 0x80)
+	} else {
+		block = append(block, 0x00)
+	}
+	block = append(block, frame...)
+
+	if err := w.write(element(idSimpleBlock, block)); err != nil {
+		return err
+	}
+	w.lastTimecode = timecode
+
+	return nil
+}
+
+// SetDimensions updates the declared video size, it only has an effect on seekable destinations
+func (w *Writer) SetDimensions(trackNumber uint64, width, height uint64) error {
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	positions, ok := w.dimensionPos[trackNumber]
+	if !ok || w.seeker == nil {
+		return nil
+	}
+
+	if err := w.patch(positions[0], uint64Bytes(width)); err != nil {
+		return err
+	}
+	if err := w.patch(positions[1], uint64Bytes(height)); err != nil {
+		return err
+	}
+	w.dimensionsSet[trackNumber] = true
+
+	return nil
+}
+
+// DimensionsSet reports whether SetDimensions has already patched the header for the track
+func (w *Writer) DimensionsSet(trackNumber uint64) bool {
+	return w.dimensionsSet[trackNumber]
+}
+
+// Close finalizes the sizes and the duration and closes the destination if it is an io.Closer
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	err := w.finalize()
+	w.closed = true
+
+	if closer, ok := w.w.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (w *Writer) finalize() error {
+	if w.seeker == nil {
+		return nil
+	}
+
+	if err := w.endCluster(); err != nil {
+		return err
+	}
+	if err := w.patch(w.segmentSizePos, encodeSizeFixed(uint64(w.offset-w.segmentDataStart), 8)); err != nil {
+		return err
+	}
+
+	duration := make([]byte, 8)
+	binary.BigEndian.PutUint64(duration, math.Float64bits(float64(w.lastTimecode)))
+	return w.patch(w.durationPos, duration)
+}
+
+func (w *Writer) writeHeader() error {
+	header := master(idEBML,
+		uintElement(idEBMLVersion, 1),
+		uintElement(idEBMLReadVersion, 1),
+		uintElement(idEBMLMaxIDLength, 4),
+		uintElement(idEBMLMaxSizeLength, 8),
+		stringElement(idDocType, "webm"),
+		uintElement(idDocTypeVersion, 4),
+		uintElement(idDocTypeReadVersion, 2),
+	)
+	if err := w.write(header); err != nil {
+		return err
+	}
+
+	if err := w.write(encodeID(idSegment)); err != nil {
+		return err
+	}
+	w.segmentSizePos = w.offset
+	if err := w.write(encodeSizeFixed(unknownSize, 8)); err != nil {
+		return err
+	}
+	w.segmentDataStart = w.offset
+
+	// Duration is the last child of Info, so its data position is known before writing
+	info := master(idInfo,
+		uintElement(idTimecodeScale, timecodeScale),
+		stringElement(idMuxingApp, muxingApp),
+		stringElement(idWritingApp, muxingApp),
+		floatElement(idDuration, 0),
+	)
+	w.durationPos = w.offset + int64(len(info)) - 8
+	if err := w.write(info); err != nil {
+		return err
+	}
+
+	return w.writeTracks()
+}
+
+func (w *Writer) writeTracks() error {
+	var (
+		entries   []byte
+		positions = make(map[uint64][2]int64)
+	)
+
+	for _, track := range w.tracks {
+		if track.Number == 0 {
+			return errors.New("webm track number must not be zero")
+		}
+
+		children := [][]byte{
+			uintElement(idTrackNumber, track.Number),
+			uintElement(idTrackUID, track.Number),
+			uintElement(idTrackType, uint64(track.Type)),
+			stringElement(idCodecID, track.Codec),
+		}
+
+		switch track.Type {
+		case TrackTypeVideo:
+			width := fixedUintElement(idPixelWidth, track.Width)
+			height := fixedUintElement(idPixelHeight, track.Height)
+			video := master(idVideo, width, height)
+
+			// Offsets are relative to the beginning of the entries until the Tracks header is known
+			childrenSize := 0
+			for _, child := range children {
+				childrenSize += len(child)
+			}
+			entryHeader := len(encodeID(idTrackEntry)) + len(encodeSize(uint64(childrenSize+len(video))))
+			videoStart := int64(len(entries) + entryHeader + childrenSize + len(video) - len(width) - len(height))
+			positions[track.Number] = [2]int64{
+				videoStart + int64(len(width)) - 8,
+				videoStart + int64(len(width)+len(height)) - 8,
+			}
+			children = append(children, video)
+		case TrackTypeAudio:
+			if track.Codec == CodecOpus {
+				children = append(children, element(idCodecPrivate, opusHead(track.Channels)))
+			}
+			children = append(children, master(idAudio,
+				floatElement(idSamplingFrequency, track.SampleRate),
+				uintElement(idChannels, track.Channels),
+			))
+		default:
+			return fmt.Errorf("unsupported webm track type: %d", track.Type)
+		}
+
+		entries = append(entries, master(idTrackEntry, children...)...)
+	}
+
+	tracksHeader := append(encodeID(idTracks), encodeSize(uint64(len(entries)))...)
+	entriesStart := w.offset + int64(len(tracksHeader))
+	for number, position := range positions {
+		w.dimensionPos[number] = [2]int64{entriesStart + position[0], entriesStart + position[1]}
+	}
+
+	return w.write(append(tracksHeader, entries...))
+}
+
+func (w *Writer) startCluster(timecode int64) error {
+	if err := w.endCluster(); err != nil {
+		return err
+	}
+
+	if err := w.write(encodeID(idCluster)); err != nil {
+		return err
+	}
+	w.clusterSizePos = w.offset
+	if err := w.write(encodeSizeFixed(unknownSize, 8)); err != nil {
+		return err
+	}
+	w.clusterDataStart = w.offset
+
+	if err := w.write(uintElement(idTimecode, uint64(timecode))); err != nil {
+		return err
+	}
+
+	w.clusterOpen = true
+	w.clusterTimecode = timecode
+	return nil
+}
+
+func (w *Writer) endCluster() error {
+	if !w.clusterOpen {
+		return nil
+	}
+	w.clusterOpen = false
+
+	if w.seeker == nil {
+		return nil
+	}
+	return w.patch(w.clusterSizePos, encodeSizeFixed(uint64(w.offset-w.clusterDataStart), 8))
+}
+
+func (w *Writer) trackType(trackNumber uint64) uint8 {
+	for _, track := range w.tracks {
+		if track.Number == trackNumber {
+			return track.Type
+		}
+	}
+	return 0
+}
+
+func (w *Writer) write(data []byte) error {
+	n, err := w.w.Write(data)
+	w.offset += int64(n)
+	if err != nil {
+		return fmt.Errorf("unable to write webm data: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) patch(position int64, data []byte) error {
+	if _, err := w.seeker.Seek(position, io.SeekStart); err != nil {
+		return fmt.Errorf("unable to seek webm destination: %w", err)
+	}
+	if _, err := w.seeker.Write(data); err != nil {
+		return fmt.Errorf("unable to patch webm destination: %w", err)
+	}
+	if _, err := w.seeker.Seek(w.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("unable to seek webm destination: %w", err)
+	}
+	return nil
+}
+
+func uint64Bytes(value uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, value)
+	return data
+}
+
+// opusHead is the CodecPrivate of Opus tracks, see RFC 7845 section 5.1
+func opusHead(channels uint64) []byte {
+	head := []byte("OpusHead")
+	head = append(head, 1, byte(channels))
+	head = append(head, 0x38, 0x01) // pre-skip: 312 samples
+	sampleRate := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sampleRate, 48000)
+	head = append(head, sampleRate...)
+	return append(head, 0, 0, 0) // output gain and channel mapping family
+}
@@ -0,0 +1,291 @@
+package webm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// seekBuffer is an in-memory io.WriteSeeker, like the recording files
+type seekBuffer struct {
+	data   []byte
+	offset int
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	if end := b.offset + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	n := copy(b.data[b.offset:], p)
+	b.offset += n
+	return n, nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		return 0, errors.New("only io.SeekStart is supported")
+	}
+	b.offset = int(offset)
+	return offset, nil
+}
+
+type ebmlElement struct {
+	id      uint32
+	data    []byte
+	unknown bool
+}
+
+// readVint returns the value of the vint at the start of data and its length
+func readVint(data []byte, keepMarker bool) (uint64, int) {
+	length := 1
+	for length <= 8 && data[0]&(0x80>>uint(length-1)) == 0 {
+		length++
+	}
+
+	value := uint64(data[0])
+	if !keepMarker {
+		value &= 0xFF >> uint(length)
+	}
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+	}
+	return value, length
+}
+
+// parseElements splits data into elements, an element of unknown size takes the rest of data
+func parseElements(t *testing.T, data []byte) []ebmlElement {
+	t.Helper()
+
+	var elements []ebmlElement
+	for len(data) > 0 {
+		id, idLength := readVint(data, true)
+		size, sizeLength := readVint(data[idLength:], false)
+		data = data[idLength+sizeLength:]
+
+		element := ebmlElement{id: uint32(id)}
+		if size == uint64(1)<<(7*uint(sizeLength))-1 {
+			element.unknown, size = true, uint64(len(data))
+		}
+		if size > uint64(len(data)) {
+			t.Fatalf("element %X of size %d exceeds the remaining %d bytes", id, size, len(data))
+		}
+		element.data, data = data[:size], data[size:]
+		elements = append(elements, element)
+	}
+	return elements
+}
+
+func child(t *testing.T, elements []ebmlElement, id uint32) ebmlElement {
+	t.Helper()
+
+	for _, element := range elements {
+		if element.id == id {
+			return element
+		}
+	}
+	t.Fatalf("element %X not found", id)
+	return ebmlElement{}
+}
+
+func uintValue(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+// segment returns the children of the Segment element
+func segment(t *testing.T, data []byte) (ebmlElement, []ebmlElement) {
+	t.Helper()
+
+	elements := parseElements(t, data)
+	if len(elements) != 2 || elements[0].id != idEBML || elements[1].id != idSegment {
+		t.Fatalf("expected the EBML header and a segment, got %d elements", len(elements))
+	}
+	return elements[1], parseElements(t, elements[1].data)
+}
+
+// blockTimecodes returns the absolute timecodes of the blocks grouped by cluster
+func blockTimecodes(t *testing.T, elements []ebmlElement) [][]int64 {
+	t.Helper()
+
+	var clusters [][]int64
+	for _, cluster := range elements {
+		if cluster.id != idCluster {
+			continue
+		}
+
+		blocks := []int64{}
+		children := parseElements(t, cluster.data)
+		timecode := int64(uintValue(child(t, children, idTimecode).data))
+		for _, block := range children {
+			if block.id == idSimpleBlock {
+				_, length := readVint(block.data, false)
+				blocks = append(blocks, timecode+int64(int16(binary.BigEndian.Uint16(block.data[length:]))))
+			}
+		}
+		clusters = append(clusters, blocks)
+	}
+	return clusters
+}
+
+var testTracks = []Track{
+	{Number: 1, Type: TrackTypeVideo, Codec: CodecVP8, Width: 320, Height: 240},
+	{Number: 2, Type: TrackTypeAudio, Codec: CodecOpus, SampleRate: 48000, Channels: 2},
+}
+
+func TestEncodeSize(t *testing.T) {
+	tests := []struct {
+		size    uint64
+		encoded []byte
+	}{
+		{0, []byte{0x80}},
+		{126, []byte{0xFE}},
+		// All ones is reserved for the unknown size
+		{127, []byte{0x40, 0x7F}},
+		{16382, []byte{0x7F, 0xFE}},
+		{16383, []byte{0x20, 0x3F, 0xFF}},
+	}
+
+	for _, test := range tests {
+		if encoded := encodeSize(test.size); !bytes.Equal(encoded, test.encoded) {
+			t.Fatalf("size %d: expected %X, got %X", test.size, test.encoded, encoded)
+		}
+	}
+}
+
+func TestWriterClusters(t *testing.T) {
+	type frame struct {
+		track    uint64
+		keyframe bool
+		timecode int64
+	}
+
+	tests := []struct {
+		name     string
+		frames   []frame
+		clusters [][]int64
+	}{
+		{"first frame opens a cluster", []frame{{2, false, 0}, {2, false, 20}}, [][]int64{{0, 20}}},
+		{"video keyframe", []frame{{1, true, 0}, {1, false, 33}, {1, true, 66}}, [][]int64{{0, 33}, {66}}},
+		{"audio keyframe", []frame{{1, true, 0}, {2, true, 20}}, [][]int64{{0, 20}}},
+		{"keyframe at the cluster timecode", []frame{{1, true, 0}, {2, false, 0}, {1, true, 0}}, [][]int64{{0, 0, 0}}},
+		{"long cluster", []frame{{1, true, 0}, {2, false, maxClusterDuration - 1}, {2, false, maxClusterDuration}}, [][]int64{{0, maxClusterDuration - 1}, {maxClusterDuration}}},
+		{"timecode going back", []frame{{1, true, 100}, {2, false, 80}}, [][]int64{{100, 100}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			destination := &seekBuffer{}
+			writer, err := NewWriter(destination, testTracks...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, frame := range test.frames {
+				if err = writer.WriteFrame(frame.track, frame.keyframe, frame.timecode, []byte{1, 2, 3}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			_, elements := segment(t, destination.data)
+			if clusters := blockTimecodes(t, elements); !reflect.DeepEqual(clusters, test.clusters) {
+				t.Fatalf("expected clusters %v, got %v", test.clusters, clusters)
+			}
+		})
+	}
+}
+
+func TestWriterPatchesSeekableDestination(t *testing.T) {
+	destination := &seekBuffer{}
+	writer, err := NewWriter(destination, testTracks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = writer.WriteFrame(1, true, 0, []byte{1})
+	if err = writer.SetDimensions(1, 640, 480); err != nil || !writer.DimensionsSet(1) {
+		t.Fatalf("expected the dimensions to be set, got %v", err)
+	}
+	_ = writer.WriteFrame(2, false, 1500, []byte{2})
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Parsing fails when a patched size does not match the data
+	seg, elements := segment(t, destination.data)
+	if seg.unknown {
+		t.Fatal("expected the segment size to be patched")
+	}
+	for _, element := range elements {
+		if element.unknown {
+			t.Fatalf("expected the size of element %X to be patched", element.id)
+		}
+	}
+
+	info := parseElements(t, child(t, elements, idInfo).data)
+	if duration := math.Float64frombits(binary.BigEndian.Uint64(child(t, info, idDuration).data)); duration != 1500 {
+		t.Fatalf("expected duration 1500, got %v", duration)
+	}
+
+	entry := parseElements(t, child(t, parseElements(t, child(t, elements, idTracks).data), idTrackEntry).data)
+	video := parseElements(t, child(t, entry, idVideo).data)
+	if width, height := uintValue(child(t, video, idPixelWidth).data), uintValue(child(t, video, idPixelHeight).data); width != 640 || height != 480 {
+		t.Fatalf("expected 640x480, got %dx%d", width, height)
+	}
+
+	if err = writer.WriteFrame(1, false, 2000, []byte{3}); !errors.Is(err, ErrWriterClosed) {
+		t.Fatalf("expected ErrWriterClosed, got %v", err)
+	}
+}
+
+func TestWriterLiveDestination(t *testing.T) {
+	destination := &bytes.Buffer{}
+	writer, err := NewWriter(destination, testTracks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = writer.WriteFrame(1, true, 0, []byte{1})
+	if err = writer.SetDimensions(1, 640, 480); err != nil || writer.DimensionsSet(1) {
+		t.Fatalf("expected the dimensions to be ignored, got %v", err)
+	}
+	_ = writer.WriteFrame(1, true, 40, []byte{2})
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	seg, elements := segment(t, destination.Bytes())
+	if !seg.unknown {
+		t.Fatal("expected the segment to keep the unknown size")
+	}
+	// A cluster of unknown size takes the rest of the segment, so only the first one is seen here
+	cluster := child(t, elements, idCluster)
+	if !cluster.unknown {
+		t.Fatal("expected the cluster to keep the unknown size")
+	}
+}
+
+func TestNewWriterErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		tracks []Track
+	}{
+		{"no tracks", nil},
+		{"zero track number", []Track{{Type: TrackTypeVideo, Codec: CodecVP8}}},
+		{"unsupported track type", []Track{{Number: 1, Type: 3, Codec: "S_TEXT/UTF8"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewWriter(&bytes.Buffer{}, test.tracks...); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package recording
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"pion-conference/pkg/recording/webm"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+)
+
+const (
+	webmVideoTrack = 1
+	webmAudioTrack = 2
+
+	defaultVideoWidth  = 640
+	defaultVideoHeight = 480
+
+	videoMaxLate = 256
+	audioMaxLate = 16
+)
+
+// WebMRecorder muxes the audio and the video of one participant into a single WebM file
+type WebMRecorder struct {
+	mux sync.Mutex
+
+	session  *Session
+	clientID string
+	closed   bool
+
+	writer      *webm.Writer
+	startOffset time.Duration
+	video       *webmTrack
+	audio       *webmTrack
+}
+
+type webmTrack struct {
+	number  uint64
+	builder *samplebuilder.SampleBuilder
+	clock   *trackClock
+	gaps    *gapDetector
+
+	waitKeyframe bool
+	width        uint64
+	height       uint64
+}
+
+func newWebMRecorder(session *Session, clientID string) *WebMRecorder {
+	return &WebMRecorder{
+		session:  session,
+		clientID: clientID,
+	}
+}
+
+func (p *WebMRecorder) WriteRTP(remote *webrtc.Track, packet *rtp.Packet) error {
+	err := p.writeRTP(remote, packet)
+	if err != nil && !errors.Is(err, ErrSessionClosed) {
+		p.session.fail(p.clientID, err)
+	}
+	return err
+}
+
+func (p *WebMRecorder) writeRTP(remote *webrtc.Track, packet *rtp.Packet) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.closed {
+		return ErrSessionClosed
+	}
+
+	track, err := p.track(remote)
+	if err != nil || track == nil {
+		return err
+	}
+
+	// The forwarding loop reuses its read buffer, samplebuilder keeps packets until the frame is complete
+	payload := make([]byte, len(packet.Payload))
+	copy(payload, packet.Payload)
+	buffered := &rtp.Packet{Header: packet.Header, Payload: payload}
+
+	track.gaps.push(packet.SequenceNumber)
+	track.builder.Push(buffered)
+
+	for {
+		sample, timestamp := track.builder.PopWithTimestamp()
+		if sample == nil {
+			return nil
+		}
+
+		if err = p.writeSample(track, sample.Data, timestamp); err != nil {
+			return err
+		}
+	}
+}
+
+// Close finishes the file, it is closed without the lock because closing may upload the rest of the file
+func (p *WebMRecorder) Close() error {
+	p.mux.Lock()
+	if p.closed {
+		p.mux.Unlock()
+		return nil
+	}
+	p.closed = true
+	writer := p.writer
+	p.mux.Unlock()
+
+	if writer == nil {
+		return nil
+	}
+	return writer.Close()
+}
+
+func (p *WebMRecorder) isClosed() bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.closed
+}
+
+func (p *WebMRecorder) track(remote *webrtc.Track) (*webmTrack, error) {
+	codec := remote.Codec()
+	if codec == nil {
+		return nil, fmt.Errorf("[%s] track %s has no negotiated codec", p.clientID, trackKey(remote))
+	}
+
+	switch {
+	case strings.EqualFold(codec.Name, webrtc.VP8):
+		if p.video == nil {
+			p.video = &webmTrack{
+				number:       webmVideoTrack,
+				builder:      samplebuilder.New(videoMaxLate, &codecs.VP8Packet{}, samplebuilder.WithPartitionHeadChecker(&codecs.VP8PartitionHeadChecker{})),
+				clock:        newTrackClock(codec.ClockRate),
+				gaps:         newGapDetector(videoMaxLate),
+				waitKeyframe: true,
+			}
+		}
+		return p.video, p.open()
+	case strings.EqualFold(codec.Name, webrtc.Opus):
+		if p.audio == nil {
+			p.audio = &webmTrack{
+				number:  webmAudioTrack,
+				builder: samplebuilder.New(audioMaxLate, &codecs.OpusPacket{}),
+				clock:   newTrackClock(codec.ClockRate),
+				gaps:    newGapDetector(audioMaxLate),
+			}
+		}
+		return p.audio, p.open()
+	}
+
+	// Unsupported tracks are not a failure of the participant recording
+	return nil, nil
+}
+
+func (p *WebMRecorder) open() error {
+	if p.writer != nil {
+		return nil
+	}
+
+	offset := p.session.offset()
+	p.startOffset = offset
+	fileName := fmt.Sprintf("%s-%d.webm", sanitizeName(p.clientID), offset.Milliseconds())
+
+	file, err := p.session.create(fileName)
+	if err != nil {
+		return err
+	}
+
+	p.writer, err = webm.NewWriter(file,
+		webm.Track{
+			Number: webmVideoTrack,
+			Type:   webm.TrackTypeVideo,
+			Codec:  webm.CodecVP8,
+			Width:  defaultVideoWidth,
+			Height: defaultVideoHeight,
+		},
+		webm.Track{
+			Number:     webmAudioTrack,
+			Type:       webm.TrackTypeAudio,
+			Codec:      webm.CodecOpus,
+			SampleRate: opusSampleRate,
+			Channels:   opusChannelCount,
+		},
+	)
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unable to create webm writer: %w", err)
+	}
+
+	p.session.addFile(FileEntry{
+		Participant: p.clientID,
+		Kind:        "audio+video",
+		Codec:       webrtc.VP8 + "+" + webrtc.Opus,
+		File:        fileName,
+		StartOffset: offset.Milliseconds(),
+	})
+
+	return nil
+}
+
+func (p *WebMRecorder) writeSample(track *webmTrack, frame []byte, timestamp uint32) error {
+	if len(frame) == 0 {
+		return nil
+	}
+
+	keyframe := true
+	if track.number == webmVideoTrack {
+		keyframe = isVP8Keyframe(frame)
+
+		// Frames after a lost packet reference data we do not have, skip them up to the next keyframe
+		if track.gaps.lost() {
+			track.waitKeyframe = true
+		}
+		if track.waitKeyframe && !keyframe {
+			return nil
+		}
+		track.waitKeyframe = false
+
+		if keyframe {
+			p.updateDimensions(track, frame)
+		}
+	}
+
+	return p.writer.WriteFrame(track.number, keyframe, track.clock.timecode(timestamp, p.session.offset()-p.startOffset), frame)
+}
+
+func (p *WebMRecorder) updateDimensions(track *webmTrack, frame []byte) {
+	width, height, ok := vp8Dimensions(frame)
+	if !ok || (width == track.width && height == track.height) {
+		return
+	}
+
+	if track.width != 0 {
+		// VP8 carries the new size in the keyframe itself, players pick it up from the bitstream
+		log.Printf("[%s] recorded video resolution changed to %dx%d", p.clientID, width, height)
+	}
+	track.width, track.height = width, height
+
+	if p.writer.DimensionsSet(track.number) {
+		return
+	}
+	if err := p.writer.SetDimensions(track.number, width, height); err != nil {
+		log.Printf("[%s] unable to update recorded video dimensions: %s", p.clientID, err)
+	}
+}
+
+// isVP8Keyframe checks the inverted key frame flag of the VP8 frame tag, see RFC 6386 section 9.1
+func isVP8Keyframe(frame []byte) bool {
+	return len(frame) > 0 && frame[0]&0x01 == 0
+}
+
+func vp8Dimensions(frame []byte) (width, height uint64, ok bool) {
+	if len(frame) < 10 || frame[3] != 0x9d || frame[4] != 0x01 || frame[5] != 0x2a {
+		return 0, 0, false
+	}
+	width = uint64(uint16(frame[6])|uint16(frame[7])<<8) & 0x3fff
+	height = uint64(uint16(frame[8])|uint16(frame[9])<<8) & 0x3fff
+	return width, height, true
+}
@@ -0,0 +1,168 @@
+// Package speaker picks the active speakers of a room from RFC 6464 audio levels
+package speaker
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Interval is the window the audio levels are averaged over
+	Interval = 300 * time.Millisecond
+	// Debounce is how long a new speakers list has to stay stable before it is reported
+	Debounce = 600 * time.Millisecond
+
+	// silenceLevel in -dBov, quieter packets are counted as silence
+	silenceLevel = 70
+	// smoothing is the weight of the last window in the score
+	smoothing = 0.35
+	// threshold is the score a participant needs to be considered speaking
+	threshold = 0.2
+)
+
+type (
+	Detector struct {
+		mux          sync.Mutex
+		participants map[string]*participant
+
+		speakers     []string
+		pending      []string
+		hasPending   bool
+		pendingSince time.Time
+
+		onChange  func(speakers []string)
+		stop      chan struct{}
+		closeOnce sync.Once
+	}
+
+	participant struct {
+		sum   float64
+		count int
+		score float64
+	}
+)
+
+// NewDetector starts the detector, onChange receives the speakers ordered from the dominant one
+func NewDetector(onChange func(speakers []string)) *Detector {
+	detector := &Detector{
+		participants: make(map[string]*participant),
+		onChange:     onChange,
+		stop:         make(chan struct{}),
+	}
+	go detector.run()
+
+	return detector
+}
+
+// Observe records the level of one audio packet, level is in -dBov as carried by the extension (0 is the loudest)
+func (d *Detector) Observe(clientID string, level uint8) {
+	loudness := 0.0
+	if level < silenceLevel {
+		loudness = float64(127-level) / 127
+	}
+
+	d.mux.Lock()
+	p, ok := d.participants[clientID]
+	if !ok {
+		p = &participant{}
+		d.participants[clientID] = p
+	}
+	p.sum += loudness
+	p.count++
+	d.mux.Unlock()
+}
+
+func (d *Detector) Remove(clientID string) {
+	d.mux.Lock()
+	delete(d.participants, clientID)
+	d.mux.Unlock()
+}
+
+// Speakers returns the last reported speakers
+func (d *Detector) Speakers() []string {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	return append([]string(nil), d.speakers...)
+}
+
+func (d *Detector) Close() {
+	d.closeOnce.Do(func() {
+		close(d.stop)
+	})
+}
+
+func (d *Detector) run() {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case now := <-ticker.C:
+			if speakers, changed := d.update(now); changed {
+				d.onChange(speakers)
+			}
+		}
+	}
+}
+
+func (d *Detector) update(now time.Time) ([]string, bool) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	scores := make(map[string]float64)
+	for clientID, p := range d.participants {
+		window := 0.0
+		if p.count > 0 {
+			window = p.sum / float64(p.count)
+		}
+		p.score = p.score*(1-smoothing) + window*smoothing
+		p.sum, p.count = 0, 0
+
+		if p.score >= threshold {
+			scores[clientID] = p.score
+		}
+	}
+
+	speakers := make([]string, 0, len(scores))
+	for clientID := range scores {
+		speakers = append(speakers, clientID)
+	}
+	sort.Slice(speakers, func(i, j int) bool {
+		if scores[speakers[i]] == scores[speakers[j]] {
+			return speakers[i] < speakers[j]
+		}
+		return scores[speakers[i]] > scores[speakers[j]]
+	})
+
+	if equal(speakers, d.speakers) {
+		d.pending, d.hasPending = nil, false
+		return nil, false
+	}
+	if !d.hasPending || !equal(speakers, d.pending) {
+		d.pending, d.hasPending = speakers, true
+		d.pendingSince = now
+	}
+	if now.Sub(d.pendingSince) < Debounce {
+		return nil, false
+	}
+
+	d.speakers = speakers
+	d.pending, d.hasPending = nil, false
+	return append([]string(nil), speakers...), true
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
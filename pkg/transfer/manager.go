@@ -0,0 +1,317 @@
+package transfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	partSuffix     = ".part"
+	metadataSuffix = ".json"
+)
+
+// Manager keeps the running transfers and the stored files under <root>/<room>/<id>
+type Manager struct {
+	mux       sync.Mutex
+	root      string
+	limits    Limits
+	transfers map[string]*Transfer
+}
+
+func NewManager(root string, limits Limits) *Manager {
+	return &Manager{
+		root:      root,
+		limits:    limits,
+		transfers: make(map[string]*Transfer),
+	}
+}
+
+// Start begins a transfer or resumes the one with the same id when the sender offers it again.
+// A new transfer reserves its size in the quota of the room until it is finished or cancelled.
+func (m *Manager) Start(room, from string, offer Offer, recipients []string) (*Transfer, error) {
+	if err := offer.validate(m.limits.MaxFileSize); err != nil {
+		return nil, err
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	key := room + "/" + offer.ID
+	if transfer, ok := m.transfers[key]; ok {
+		if transfer.From != from || transfer.Size != offer.Size || transfer.ChunkSize != offer.ChunkSize {
+			return nil, ErrTransferExists
+		}
+		return transfer, nil
+	}
+	if err := m.checkLimits(room, from, offer.Size); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(m.root, url.PathEscape(room))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create files directory: %w", err)
+	}
+
+	// Stored files are never replaced, the transfers are not known anymore after a restart
+	path := filepath.Join(dir, url.PathEscape(offer.ID))
+	for _, stored := range []string{path, path + metadataSuffix} {
+		if _, err := os.Stat(stored); err == nil || !os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrFileExists, offer.ID)
+		}
+	}
+
+	file, err := os.Create(path + partSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create file: %w", err)
+	}
+
+	transfer := &Transfer{
+		File:       File{Offer: offer, From: from, Created: time.Now().UTC()},
+		room:       room,
+		Recipients: recipients,
+		path:       path,
+		file:       file,
+		chunks:     make([]bool, offer.Chunks()),
+		relayed:    make([]bool, offer.Chunks()),
+		active:     time.Now(),
+	}
+	if m.limits.IdleTimeout > 0 {
+		transfer.timer = time.AfterFunc(m.limits.IdleTimeout, func() {
+			m.expire(transfer)
+		})
+	}
+	m.transfers[key] = transfer
+	return transfer, nil
+}
+
+// checkLimits counts the unfinished transfers of the sender and the bytes of the room, it is called with the lock held
+func (m *Manager) checkLimits(room, from string, size int64) error {
+	var (
+		transfers int
+		used      int64
+	)
+	for _, transfer := range m.transfers {
+		if transfer.room != room || !transfer.running() {
+			continue
+		}
+		used += transfer.Size
+		if transfer.From == from {
+			transfers++
+		}
+	}
+
+	if m.limits.MaxTransfers > 0 && transfers >= m.limits.MaxTransfers {
+		return fmt.Errorf("%w: at most %d are allowed", ErrTooManyTransfers, m.limits.MaxTransfers)
+	}
+	if m.limits.RoomQuota <= 0 {
+		return nil
+	}
+
+	stored, err := m.Files(room)
+	if err != nil {
+		return err
+	}
+	for _, file := range stored {
+		used += file.Size
+	}
+	if used+size > m.limits.RoomQuota {
+		return fmt.Errorf("%w: %d of %d bytes are used", ErrRoomQuota, used, m.limits.RoomQuota)
+	}
+	return nil
+}
+
+// expire cancels a transfer which received no chunk within the idle timeout
+func (m *Manager) expire(transfer *Transfer) {
+	transfer.mux.Lock()
+	if transfer.file == nil {
+		transfer.mux.Unlock()
+		return
+	}
+	if idle := time.Since(transfer.active); idle < m.limits.IdleTimeout {
+		transfer.timer.Reset(m.limits.IdleTimeout - idle)
+		transfer.mux.Unlock()
+		return
+	}
+	onExpire := transfer.onExpire
+	transfer.mux.Unlock()
+
+	if err := m.Cancel(transfer); err != nil {
+		log.Printf("[%s] unable to cancel idle file %s: %s", transfer.room, transfer.ID, err)
+	}
+	if onExpire != nil {
+		onExpire()
+	}
+}
+
+// Transfer returns a running transfer or a stored file
+func (m *Manager) Transfer(room, id string) (*Transfer, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	transfer, ok := m.transfers[room+"/"+id]
+	return transfer, ok
+}
+
+// Finish closes a complete transfer, stored files are kept for download and the others are removed.
+// Transfers are forgotten after their lock is released, checkLimits takes the manager lock first.
+func (m *Manager) Finish(transfer *Transfer) error {
+	transfer.mux.Lock()
+	if transfer.file == nil {
+		transfer.mux.Unlock()
+		return nil
+	}
+	transfer.stopTimer()
+	err := transfer.file.Close()
+	transfer.file = nil
+	if err != nil {
+		err = fmt.Errorf("unable to close file: %w", err)
+	} else if transfer.Store {
+		// Recipients read the chunks from the stored file once the part file is closed
+		err = transfer.store()
+	}
+	transfer.mux.Unlock()
+
+	if !transfer.Store {
+		m.forget(transfer)
+		if removeErr := os.Remove(transfer.path + partSuffix); err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+// store writes the metadata and moves the part file in place, it is called with the lock of the transfer held
+func (t *Transfer) store() error {
+	metadata, err := json.Marshal(t.File)
+	if err != nil {
+		return fmt.Errorf("unable to marshal file metadata: %w", err)
+	}
+	if err = ioutil.WriteFile(t.path+metadataSuffix, metadata, 0644); err != nil {
+		return fmt.Errorf("unable to write file metadata: %w", err)
+	}
+	if err = os.Rename(t.path+partSuffix, t.path); err != nil {
+		return fmt.Errorf("unable to store file: %w", err)
+	}
+	return nil
+}
+
+// Cancel drops a transfer which is not complete
+func (m *Manager) Cancel(transfer *Transfer) error {
+	transfer.mux.Lock()
+	if transfer.file == nil {
+		transfer.mux.Unlock()
+		return nil
+	}
+	transfer.stopTimer()
+	_ = transfer.file.Close()
+	transfer.file = nil
+	transfer.mux.Unlock()
+
+	m.forget(transfer)
+	return os.Remove(transfer.path + partSuffix)
+}
+
+// CancelFrom drops the unfinished transfers of a sender who left the room and returns them
+func (m *Manager) CancelFrom(room, from string) []*Transfer {
+	m.mux.Lock()
+	var transfers []*Transfer
+	for _, transfer := range m.transfers {
+		if transfer.room == room && transfer.From == from {
+			transfers = append(transfers, transfer)
+		}
+	}
+	m.mux.Unlock()
+
+	cancelled := transfers[:0]
+	for _, transfer := range transfers {
+		if !transfer.running() {
+			continue
+		}
+		if err := m.Cancel(transfer); err != nil {
+			log.Printf("[%s] unable to cancel file %s of %s: %s", room, transfer.ID, from, err)
+		}
+		cancelled = append(cancelled, transfer)
+	}
+	return cancelled
+}
+
+func (m *Manager) forget(transfer *Transfer) {
+	m.mux.Lock()
+	delete(m.transfers, transfer.room+"/"+transfer.ID)
+	m.mux.Unlock()
+}
+
+// Files lists the stored files of the room, the oldest first
+func (m *Manager) Files(room string) ([]File, error) {
+	files := make([]File, 0)
+
+	entries, err := ioutil.ReadDir(filepath.Join(m.root, url.PathEscape(room)))
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to list files: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), metadataSuffix) {
+			continue
+		}
+
+		file, err := m.readMetadata(filepath.Join(m.root, url.PathEscape(room), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Created.Before(files[j].Created)
+	})
+	return files, nil
+}
+
+// Open returns a stored file, the caller closes it
+func (m *Manager) Open(room, id string) (*os.File, File, error) {
+	if !validID(id) {
+		return nil, File{}, ErrTransferUnknown
+	}
+	path := filepath.Join(m.root, url.PathEscape(room), url.PathEscape(id))
+
+	metadata, err := m.readMetadata(path + metadataSuffix)
+	if err != nil {
+		return nil, File{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, File{}, fmt.Errorf("unable to open file: %w", err)
+	}
+	return file, metadata, nil
+}
+
+func (m *Manager) readMetadata(path string) (File, error) {
+	var file File
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return file, ErrTransferUnknown
+	}
+	if err != nil {
+		return file, fmt.Errorf("unable to read file metadata: %w", err)
+	}
+
+	if err = json.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("unable to parse file metadata: %w", err)
+	}
+	return file, nil
+}
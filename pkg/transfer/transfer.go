@@ -0,0 +1,300 @@
+// Package transfer keeps the files participants send to each other in chunks, so transfers can resume
+// and completed files can be stored for participants who join later.
+package transfer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxFileSize limits the size of a single file
+	DefaultMaxFileSize = 100 << 20
+	// DefaultMaxTransfers limits the unfinished transfers of one sender
+	DefaultMaxTransfers = 4
+	// DefaultRoomQuota limits the stored files and the unfinished transfers of a room
+	DefaultRoomQuota = 1 << 30
+	// DefaultIdleTimeout cancels unfinished transfers which received no chunk for that long
+	DefaultIdleTimeout = 2 * time.Minute
+	// MaxChunkSize keeps every chunk below the message size which browsers accept on data channels
+	MaxChunkSize = 64 << 10
+	// MaxIDLength limits the ids of the offers, they consist of letters, digits, '-' and '_'
+	MaxIDLength = 64
+)
+
+var (
+	ErrFileExists       = errors.New("file is already stored")
+	ErrFileTooLarge     = errors.New("file is too large")
+	ErrInvalidChunk     = errors.New("invalid chunk")
+	ErrInvalidOffer     = errors.New("invalid file offer")
+	ErrRoomQuota        = errors.New("room file quota exceeded")
+	ErrTooManyTransfers = errors.New("too many unfinished transfers")
+	ErrTransferExists   = errors.New("transfer was started by another participant")
+	ErrTransferIdle     = errors.New("transfer received no chunk for too long")
+	ErrTransferUnknown  = errors.New("transfer not found")
+)
+
+type (
+	// Limits bound the files of the rooms, a zero value does not limit
+	Limits struct {
+		MaxFileSize  int64
+		MaxTransfers int
+		RoomQuota    int64
+		IdleTimeout  time.Duration
+	}
+
+	// Offer describes a file a participant is about to send, To is empty when it goes to the whole room
+	Offer struct {
+		ID        string   `json:"id"`
+		Name      string   `json:"name"`
+		Size      int64    `json:"size"`
+		MimeType  string   `json:"mimeType,omitempty"`
+		ChunkSize int      `json:"chunkSize"`
+		To        []string `json:"to,omitempty"`
+		Store     bool     `json:"store,omitempty"`
+	}
+
+	// File is a stored file which can be downloaded
+	File struct {
+		Offer
+		From    string    `json:"from"`
+		Created time.Time `json:"created"`
+	}
+
+	// Transfer receives the chunks of one file into a part file, chunks may arrive again after a resume
+	Transfer struct {
+		mux sync.Mutex
+
+		File
+		room       string
+		Recipients []string
+
+		path     string
+		file     *os.File
+		chunks   []bool
+		received int
+
+		// relayed are the chunks every recipient got, refused are the recipients which did not take
+		// a chunk yet and get it when the sender sends it again
+		relayed      []bool
+		relayedCount int
+		refused      map[int][]string
+
+		// active is the time of the offer or the last chunk, idle transfers are cancelled by timer
+		active   time.Time
+		timer    *time.Timer
+		onExpire func()
+	}
+)
+
+// DefaultLimits returns the limits of the Default constants
+func DefaultLimits() Limits {
+	return Limits{
+		MaxFileSize:  DefaultMaxFileSize,
+		MaxTransfers: DefaultMaxTransfers,
+		RoomQuota:    DefaultRoomQuota,
+		IdleTimeout:  DefaultIdleTimeout,
+	}
+}
+
+func (o Offer) validate(maxSize int64) error {
+	switch {
+	case o.ID == "" || o.Name == "":
+		return fmt.Errorf("%w: id and name are required", ErrInvalidOffer)
+	case len(o.ID) > MaxIDLength:
+		return fmt.Errorf("%w: id is longer than %d bytes", ErrInvalidOffer, MaxIDLength)
+	case !validID(o.ID):
+		return fmt.Errorf("%w: id may only contain letters, digits, '-' and '_'", ErrInvalidOffer)
+	case o.ChunkSize <= 0 || o.ChunkSize > MaxChunkSize:
+		return fmt.Errorf("%w: chunk size must be between 1 and %d", ErrInvalidOffer, MaxChunkSize)
+	case o.Size <= 0:
+		return fmt.Errorf("%w: size must be positive", ErrInvalidOffer)
+	case maxSize > 0 && o.Size > maxSize:
+		return fmt.Errorf("%w: %d bytes, at most %d are allowed", ErrFileTooLarge, o.Size, maxSize)
+	}
+	return nil
+}
+
+// validID keeps the ids apart from the suffixes of the part and metadata files next to the stored files
+func validID(id string) bool {
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Chunks is the number of chunks of the file
+func (o Offer) Chunks() int {
+	return int((o.Size + int64(o.ChunkSize) - 1) / int64(o.ChunkSize))
+}
+
+// WriteChunk stores a chunk at its position, a chunk which was already received is ignored
+func (t *Transfer) WriteChunk(index int, data []byte) (complete bool, err error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if index < 0 || index >= len(t.chunks) || len(data) != t.chunkLength(index) {
+		return false, ErrInvalidChunk
+	}
+	if t.file == nil {
+		return false, ErrTransferUnknown
+	}
+	if t.chunks[index] {
+		return t.received == len(t.chunks), nil
+	}
+
+	if _, err = t.file.WriteAt(data, int64(index)*int64(t.ChunkSize)); err != nil {
+		return false, fmt.Errorf("unable to write chunk: %w", err)
+	}
+	t.chunks[index] = true
+	t.received++
+	t.active = time.Now()
+	return t.received == len(t.chunks), nil
+}
+
+// Refuse records the recipients which did not take a chunk, the sender is asked to send it again
+func (t *Transfer) Refuse(index int, recipients []string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if t.refused == nil {
+		t.refused = make(map[int][]string)
+	}
+	t.refused[index] = append(t.refused[index], recipients...)
+}
+
+// Retry returns the recipients which refused a chunk the sender sent again, ok is false when nobody did
+func (t *Transfer) Retry(index int) (recipients []string, ok bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	recipients, ok = t.refused[index]
+	delete(t.refused, index)
+	return recipients, ok
+}
+
+// Resume is the chunk a sender who offers the transfer again continues with, it is before Next
+// when a recipient still waits for a refused chunk
+func (t *Transfer) Resume() int {
+	next := t.Next()
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for index := range t.refused {
+		if index < next {
+			next = index
+		}
+	}
+	return next
+}
+
+// Relayed marks a chunk as taken by every recipient, it reports true once, when the last chunk was relayed
+func (t *Transfer) Relayed(index int) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if index < 0 || index >= len(t.relayed) || t.relayed[index] {
+		return false
+	}
+	t.relayed[index] = true
+	t.relayedCount++
+	return t.relayedCount == len(t.relayed)
+}
+
+// OnExpire sets the function which is called when the transfer is cancelled because it was idle
+func (t *Transfer) OnExpire(handler func()) {
+	t.mux.Lock()
+	t.onExpire = handler
+	t.mux.Unlock()
+}
+
+func (t *Transfer) stopTimer() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// running reports whether the transfer still receives chunks into its part file
+func (t *Transfer) running() bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.file != nil
+}
+
+// ReadChunk returns a received chunk, recipients use it to resume
+func (t *Transfer) ReadChunk(index int) ([]byte, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if index < 0 || index >= len(t.chunks) || !t.chunks[index] {
+		return nil, ErrInvalidChunk
+	}
+
+	file := t.file
+	if file == nil {
+		var err error
+		if file, err = os.Open(t.path); err != nil {
+			return nil, fmt.Errorf("unable to open file: %w", err)
+		}
+		defer file.Close()
+	}
+
+	data := make([]byte, t.chunkLength(index))
+	if _, err := file.ReadAt(data, int64(index)*int64(t.ChunkSize)); err != nil {
+		return nil, fmt.Errorf("unable to read chunk: %w", err)
+	}
+	return data, nil
+}
+
+// Next is the first chunk which was not received yet, the sender resumes from there
+func (t *Transfer) Next() int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for index, received := range t.chunks {
+		if !received {
+			return index
+		}
+	}
+	return len(t.chunks)
+}
+
+func (t *Transfer) chunkLength(index int) int {
+	if index == len(t.chunks)-1 {
+		return int(t.Size - int64(index)*int64(t.ChunkSize))
+	}
+	return t.ChunkSize
+}
+
+// MarshalChunk frames a chunk as a binary data channel message: the length of the transfer id, the id,
+// the big endian chunk index and the data
+func MarshalChunk(id string, index int, data []byte) []byte {
+	frame := make([]byte, 0, 1+len(id)+4+len(data))
+	frame = append(frame, byte(len(id)))
+	frame = append(frame, id...)
+	frame = append(frame, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(frame[1+len(id):], uint32(index))
+	return append(frame, data...)
+}
+
+func UnmarshalChunk(frame []byte) (id string, index int, data []byte, err error) {
+	if len(frame) < 1 {
+		return "", 0, nil, ErrInvalidChunk
+	}
+	size := int(frame[0])
+	if len(frame) < 1+size+4 || size == 0 {
+		return "", 0, nil, ErrInvalidChunk
+	}
+
+	id = string(frame[1 : 1+size])
+	index = int(binary.BigEndian.Uint32(frame[1+size:]))
+	return id, index, frame[1+size+4:], nil
+}
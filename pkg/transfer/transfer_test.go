@@ -0,0 +1,292 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChunkFraming(t *testing.T) {
+	data := []byte("chunk data")
+
+	id, index, got, err := UnmarshalChunk(MarshalChunk("file-1", 70000, data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if id != "file-1" || index != 70000 || !bytes.Equal(got, data) {
+		t.Fatalf("got %q %d %q", id, index, got)
+	}
+}
+
+func TestUnmarshalChunkInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"empty", nil},
+		{"empty id", []byte{0, 0, 0, 0, 1}},
+		{"short id", []byte{5, 'a', 'b'}},
+		{"short index", []byte{1, 'a', 0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, _, err := UnmarshalChunk(test.frame); !errors.Is(err, ErrInvalidChunk) {
+				t.Fatalf("expected ErrInvalidChunk, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOfferValidate(t *testing.T) {
+	valid := Offer{ID: "file_1-a", Name: "a.txt", Size: 10, ChunkSize: 4}
+
+	tests := []struct {
+		name   string
+		change func(*Offer)
+		err    error
+	}{
+		{"valid", func(*Offer) {}, nil},
+		{"no id", func(o *Offer) { o.ID = "" }, ErrInvalidOffer},
+		{"no name", func(o *Offer) { o.Name = "" }, ErrInvalidOffer},
+		{"long id", func(o *Offer) { o.ID = strings.Repeat("a", MaxIDLength+1) }, ErrInvalidOffer},
+		{"metadata suffix", func(o *Offer) { o.ID = "a.json" }, ErrInvalidOffer},
+		{"path", func(o *Offer) { o.ID = "../a" }, ErrInvalidOffer},
+		{"no chunk size", func(o *Offer) { o.ChunkSize = 0 }, ErrInvalidOffer},
+		{"large chunks", func(o *Offer) { o.ChunkSize = MaxChunkSize + 1 }, ErrInvalidOffer},
+		{"no size", func(o *Offer) { o.Size = 0 }, ErrInvalidOffer},
+		{"too large", func(o *Offer) { o.Size = 101 }, ErrFileTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			offer := valid
+			test.change(&offer)
+
+			err := offer.validate(100)
+			if test.err == nil && err != nil || !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestManagerStoresFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	manager := NewManager(root, DefaultLimits())
+	offer := Offer{ID: "a", Name: "a.txt", Size: 6, ChunkSize: 4, Store: true}
+
+	transfer, err := manager.Start("room", "carol", offer, nil)
+	if err != nil {
+		t.Fatalf("unable to start: %s", err)
+	}
+	if _, err = manager.Start("room", "bob", offer, nil); !errors.Is(err, ErrTransferExists) {
+		t.Fatalf("expected ErrTransferExists for another sender, got %v", err)
+	}
+
+	if complete, err := transfer.WriteChunk(1, []byte("ef")); err != nil || complete {
+		t.Fatalf("chunk 1: complete %t, err %v", complete, err)
+	}
+	if next := transfer.Next(); next != 0 {
+		t.Fatalf("expected next chunk 0, got %d", next)
+	}
+	if complete, err := transfer.WriteChunk(0, []byte("abcd")); err != nil || !complete {
+		t.Fatalf("chunk 0: complete %t, err %v", complete, err)
+	}
+	if err = manager.Finish(transfer); err != nil {
+		t.Fatalf("unable to finish: %s", err)
+	}
+
+	file, stored, err := manager.Open("room", "a")
+	if err != nil {
+		t.Fatalf("unable to open: %s", err)
+	}
+	data, _ := ioutil.ReadAll(file)
+	file.Close()
+	if string(data) != "abcdef" || stored.From != "carol" {
+		t.Fatalf("got %q from %s", data, stored.From)
+	}
+
+	// A new manager does not know the transfer anymore, like after a restart
+	restarted := NewManager(root, DefaultLimits())
+	if _, err = restarted.Start("room", "bob", offer, nil); !errors.Is(err, ErrFileExists) {
+		t.Fatalf("expected ErrFileExists, got %v", err)
+	}
+
+	files, err := restarted.Files("room")
+	if err != nil || len(files) != 1 || files[0].ID != "a" {
+		t.Fatalf("got %v, %v", files, err)
+	}
+}
+
+func tempRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(root)
+	})
+	return root
+}
+
+func TestManagerLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		// offers are started in order by carol unless from is set, the last one is checked
+		offers []Offer
+		from   string
+		err    error
+	}{
+		{"within the limits", Limits{MaxTransfers: 2, RoomQuota: 20}, []Offer{{ID: "a", Size: 10}, {ID: "b", Size: 10}}, "", nil},
+		{"too many transfers", Limits{MaxTransfers: 1}, []Offer{{ID: "a", Size: 10}, {ID: "b", Size: 10}}, "", ErrTooManyTransfers},
+		{"transfers of another sender", Limits{MaxTransfers: 1}, []Offer{{ID: "a", Size: 10}, {ID: "b", Size: 10}}, "bob", nil},
+		{"room quota", Limits{RoomQuota: 15}, []Offer{{ID: "a", Size: 10}, {ID: "b", Size: 10}}, "bob", ErrRoomQuota},
+		{"stored files count", Limits{RoomQuota: 15}, []Offer{{ID: "a", Size: 10, Store: true}, {ID: "b", Size: 10}}, "", ErrRoomQuota},
+		{"resume", Limits{MaxTransfers: 1, RoomQuota: 10}, []Offer{{ID: "a", Size: 10}, {ID: "a", Size: 10}}, "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := NewManager(tempRoot(t), test.limits)
+
+			var err error
+			for i, offer := range test.offers {
+				offer.Name, offer.ChunkSize = offer.ID, 10
+				from := "carol"
+				if i == len(test.offers)-1 && test.from != "" {
+					from = test.from
+				}
+
+				var transfer *Transfer
+				if transfer, err = manager.Start("room", from, offer, nil); err != nil {
+					break
+				}
+				if offer.Store {
+					_, _ = transfer.WriteChunk(0, make([]byte, offer.Size))
+					if err = manager.Finish(transfer); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if test.err == nil && err != nil || !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestManagerCancelFrom(t *testing.T) {
+	root := tempRoot(t)
+	manager := NewManager(root, Limits{MaxTransfers: 1})
+
+	offer := Offer{ID: "a", Name: "a.txt", Size: 10, ChunkSize: 10}
+	if _, err := manager.Start("room", "carol", offer, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if cancelled := manager.CancelFrom("room", "bob"); len(cancelled) != 0 {
+		t.Fatalf("expected no transfers of bob, got %d", len(cancelled))
+	}
+	if cancelled := manager.CancelFrom("room", "carol"); len(cancelled) != 1 || cancelled[0].ID != "a" {
+		t.Fatalf("expected the transfer of carol to be cancelled, got %v", cancelled)
+	}
+
+	if _, ok := manager.Transfer("room", "a"); ok {
+		t.Fatal("expected the transfer to be forgotten")
+	}
+	if _, err := os.Stat(filepath.Join(root, "room", "a"+partSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("expected the part file to be removed, got %v", err)
+	}
+	// The cancelled transfer does not count anymore
+	if _, err := manager.Start("room", "carol", Offer{ID: "b", Name: "b.txt", Size: 10, ChunkSize: 10}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagerExpiresIdleTransfers(t *testing.T) {
+	root := tempRoot(t)
+	manager := NewManager(root, Limits{IdleTimeout: 50 * time.Millisecond})
+
+	transfer, err := manager.Start("room", "carol", Offer{ID: "a", Name: "a.txt", Size: 20, ChunkSize: 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := make(chan struct{})
+	transfer.OnExpire(func() {
+		close(expired)
+	})
+
+	// A chunk keeps the transfer alive
+	time.Sleep(30 * time.Millisecond)
+	if _, err = transfer.WriteChunk(0, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := manager.Transfer("room", "a"); !ok {
+		t.Fatal("expected the transfer to be kept after a chunk")
+	}
+
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("expected the idle transfer to expire")
+	}
+	if _, ok := manager.Transfer("room", "a"); ok {
+		t.Fatal("expected the transfer to be forgotten")
+	}
+	if _, err = os.Stat(filepath.Join(root, "room", "a"+partSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("expected the part file to be removed, got %v", err)
+	}
+}
+
+func TestTransferRelay(t *testing.T) {
+	manager := NewManager(tempRoot(t), Limits{})
+	transfer, err := manager.Start("room", "carol", Offer{ID: "a", Name: "a.txt", Size: 20, ChunkSize: 10}, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = transfer.WriteChunk(0, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := transfer.Retry(0); ok {
+		t.Fatal("expected a chunk sent for the first time not to be a retry")
+	}
+	transfer.Refuse(0, []string{"bob"})
+	if _, err = transfer.WriteChunk(1, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Relayed(1) {
+		t.Fatal("expected the transfer not to be relayed while bob waits for a chunk")
+	}
+	if next := transfer.Resume(); next != 0 {
+		t.Fatalf("expected a resuming sender to start with the refused chunk, got %d", next)
+	}
+
+	recipients, ok := transfer.Retry(0)
+	if !ok || len(recipients) != 1 || recipients[0] != "bob" {
+		t.Fatalf("expected bob to get the chunk again, got %v", recipients)
+	}
+	if _, ok = transfer.Retry(0); ok {
+		t.Fatal("expected the retry to be taken once")
+	}
+	if !transfer.Relayed(0) {
+		t.Fatal("expected the transfer to be relayed after the last chunk")
+	}
+	if transfer.Relayed(0) {
+		t.Fatal("expected a chunk relayed again not to complete the transfer twice")
+	}
+}
@@ -0,0 +1,69 @@
+package webrtc
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+// AudioLevelURI is the RFC 6464 client-to-mixer audio level header extension
+const AudioLevelURI = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+
+// videoRTCPFeedback lets the clients request keyframes and retransmissions from the server and answer them,
+// subscribers report their bandwidth estimate with REMB
+var videoRTCPFeedback = []webrtc.RTCPFeedback{
+	{Type: "ccm", Parameter: "fir"},
+	{Type: "nack"},
+	{Type: "nack", Parameter: "pli"},
+	{Type: "goog-remb"},
+}
+
+// newListenAPI creates the API of the peers which send the room media to subscribers and viewers
+func newListenAPI(policy CodecPolicy) *webrtc.API {
+	return webrtc.NewAPI(webrtc.WithMediaEngine(policy.mediaEngine()))
+}
+
+// newBroadCastAPI creates the API of the peers which receive media from clients,
+// they negotiate the header extensions the forwarding loop reads
+func newBroadCastAPI(policy CodecPolicy) *webrtc.API {
+	mediaEngine := policy.mediaEngine()
+
+	audioLevel, _ := url.Parse(AudioLevelURI)
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.AddSDPExtensions(webrtc.SDPSectionAudio, []sdp.ExtMap{{URI: audioLevel}})
+
+	return webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithSettingEngine(settingEngine))
+}
+
+// extensionID returns the id the remote description assigned to the header extension in the media sections of kind
+func extensionID(description *webrtc.SessionDescription, kind webrtc.RTPCodecType, uri string) uint8 {
+	if description == nil {
+		return 0
+	}
+
+	inKind := false
+	for _, line := range strings.Split(description.SDP, "\r\n") {
+		if strings.HasPrefix(line, "m=") {
+			inKind = strings.HasPrefix(line, "m="+kind.String()+" ")
+			continue
+		}
+		if !inKind || !strings.HasPrefix(line, "a=extmap:") {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, "a=extmap:"))
+		if len(fields) < 2 || fields[1] != uri {
+			continue
+		}
+		// The id may be followed by the direction, e.g. "1/recvonly"
+		id, err := strconv.ParseUint(strings.SplitN(fields[0], "/", 2)[0], 10, 8)
+		if err != nil {
+			continue
+		}
+		return uint8(id)
+	}
+	return 0
+}
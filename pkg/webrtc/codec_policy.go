@@ -0,0 +1,131 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+const defaultH264Fmtp = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f"
+
+type (
+	// CodecPolicy is the set of codecs the peers of a room negotiate, in the order of preference
+	CodecPolicy struct {
+		Codecs []CodecPreference `json:"codecs"`
+	}
+
+	// CodecPreference is one allowed codec, a codec may be listed several times with different fmtp profiles
+	// as long as every entry has its own payload type
+	CodecPreference struct {
+		Name        string `json:"name"`
+		Fmtp        string `json:"fmtp,omitempty"`
+		PayloadType uint8  `json:"payloadType,omitempty"`
+	}
+)
+
+// DefaultCodecPolicy is applied to new rooms
+var DefaultCodecPolicy = CodecPolicy{
+	Codecs: []CodecPreference{
+		{Name: webrtc.VP8},
+		{Name: webrtc.VP9},
+		{Name: webrtc.H264},
+		{Name: webrtc.Opus},
+	},
+}
+
+var defaultPayloadTypes = map[string]uint8{
+	strings.ToLower(webrtc.VP8):  webrtc.DefaultPayloadTypeVP8,
+	strings.ToLower(webrtc.VP9):  webrtc.DefaultPayloadTypeVP9,
+	strings.ToLower(webrtc.H264): webrtc.DefaultPayloadTypeH264,
+	strings.ToLower(webrtc.Opus): webrtc.DefaultPayloadTypeOpus,
+}
+
+// ParseCodecPolicy builds a policy from a comma separated list of codec names, e.g. "VP8,H264,opus"
+func ParseCodecPolicy(value string) (CodecPolicy, error) {
+	var policy CodecPolicy
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			policy.Codecs = append(policy.Codecs, CodecPreference{Name: name})
+		}
+	}
+	return policy, policy.Validate()
+}
+
+func (p CodecPolicy) Validate() error {
+	if len(p.Codecs) == 0 {
+		return errors.New("codec policy must allow at least one codec")
+	}
+
+	payloadTypes := make(map[uint8]string)
+	for _, codec := range p.Codecs {
+		if _, ok := defaultPayloadTypes[strings.ToLower(codec.Name)]; !ok {
+			return fmt.Errorf("unsupported codec: %s", codec.Name)
+		}
+		if codec.PayloadType != 0 && (codec.PayloadType < 96 || codec.PayloadType > 127) {
+			return fmt.Errorf("payload type of %s must be dynamic (96-127): %d", codec.Name, codec.PayloadType)
+		}
+
+		payloadType := codec.payloadType()
+		if other, ok := payloadTypes[payloadType]; ok {
+			return fmt.Errorf("payload type %d is used by %s and %s", payloadType, other, codec.Name)
+		}
+		payloadTypes[payloadType] = codec.Name
+	}
+	return nil
+}
+
+// Allows reports whether tracks of the codec may be sent to the peers of the room
+func (p CodecPolicy) Allows(name string) bool {
+	for _, codec := range p.Codecs {
+		if strings.EqualFold(codec.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p CodecPolicy) allowsKind(kind webrtc.RTPCodecType) bool {
+	mediaEngine := p.mediaEngine()
+	return len(mediaEngine.GetCodecsByKind(kind)) != 0
+}
+
+// mediaEngine registers the allowed codecs in the order of preference,
+// video codecs announce the RTCP feedback the forwarder handles
+func (p CodecPolicy) mediaEngine() webrtc.MediaEngine {
+	mediaEngine := webrtc.MediaEngine{}
+
+	for _, codec := range p.Codecs {
+		payloadType := codec.payloadType()
+
+		switch strings.ToLower(codec.Name) {
+		case strings.ToLower(webrtc.VP8):
+			mediaEngine.RegisterCodec(webrtc.NewRTPVP8CodecExt(payloadType, 90000, videoRTCPFeedback, codec.Fmtp))
+		case strings.ToLower(webrtc.VP9):
+			mediaEngine.RegisterCodec(webrtc.NewRTPVP9CodecExt(payloadType, 90000, videoRTCPFeedback, codec.Fmtp))
+		case strings.ToLower(webrtc.H264):
+			fmtp := codec.Fmtp
+			if fmtp == "" {
+				fmtp = defaultH264Fmtp
+			}
+			mediaEngine.RegisterCodec(webrtc.NewRTPH264CodecExt(payloadType, 90000, videoRTCPFeedback, fmtp))
+		case strings.ToLower(webrtc.Opus):
+			fmtp := codec.Fmtp
+			if fmtp == "" {
+				fmtp = "minptime=10;useinbandfec=1"
+			}
+			mediaEngine.RegisterCodec(webrtc.NewRTPCodec(webrtc.RTPCodecTypeAudio, webrtc.Opus, 48000, 2, fmtp, payloadType, &codecs.OpusPayloader{}))
+		}
+	}
+
+	return mediaEngine
+}
+
+func (c CodecPreference) payloadType() uint8 {
+	if c.PayloadType != 0 {
+		return c.PayloadType
+	}
+	return defaultPayloadTypes[strings.ToLower(c.Name)]
+}
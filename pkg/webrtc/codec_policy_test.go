@@ -0,0 +1,82 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestParseCodecPolicy(t *testing.T) {
+	tests := []struct {
+		value  string
+		codecs []string
+		valid  bool
+	}{
+		{"VP8,H264,opus", []string{"VP8", "H264", "opus"}, true},
+		{" vp9 , opus ,", []string{"vp9", "opus"}, true},
+		{"opus", []string{"opus"}, true},
+		{"", nil, false},
+		{" , ", nil, false},
+		{"VP8,AV1", nil, false},
+		// The same codec twice gets the same default payload type
+		{"VP8,vp8", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			policy, err := ParseCodecPolicy(test.value)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", policy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(policy.Codecs) != len(test.codecs) {
+				t.Fatalf("expected %v, got %v", test.codecs, policy.Codecs)
+			}
+			for i, codec := range policy.Codecs {
+				if codec.Name != test.codecs[i] {
+					t.Fatalf("expected %v, got %v", test.codecs, policy.Codecs)
+				}
+			}
+		})
+	}
+}
+
+func TestCodecPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		codecs []CodecPreference
+		valid  bool
+	}{
+		{"h264 profiles", []CodecPreference{{Name: webrtc.H264}, {Name: webrtc.H264, Fmtp: "profile-level-id=640032", PayloadType: 110}}, true},
+		{"static payload type", []CodecPreference{{Name: webrtc.VP8, PayloadType: 8}}, false},
+		{"payload type clash", []CodecPreference{{Name: webrtc.VP8}, {Name: webrtc.VP9, PayloadType: webrtc.DefaultPayloadTypeVP8}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := (CodecPolicy{Codecs: test.codecs}).Validate(); (err == nil) != test.valid {
+				t.Fatalf("expected valid %t, got %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestCodecPolicyAllows(t *testing.T) {
+	policy := CodecPolicy{Codecs: []CodecPreference{{Name: webrtc.VP8}, {Name: webrtc.Opus}}}
+
+	if !policy.Allows("vp8") || !policy.Allows("OPUS") || policy.Allows(webrtc.H264) {
+		t.Fatal("codec names must be compared case-insensitively")
+	}
+	if !policy.allowsKind(webrtc.RTPCodecTypeVideo) {
+		t.Fatal("expected video to be allowed")
+	}
+	if (CodecPolicy{Codecs: []CodecPreference{{Name: webrtc.Opus}}}).allowsKind(webrtc.RTPCodecTypeVideo) {
+		t.Fatal("expected an audio-only policy to refuse video")
+	}
+}
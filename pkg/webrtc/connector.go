@@ -3,17 +3,17 @@ package webrtc
 import (
 	"errors"
 	"fmt"
-	"io"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/pion/rtcp"
+	"pion-conference/pkg/webrtc/track"
+
 	"github.com/pion/webrtc/v3"
 )
 
-const PLISendInterval = time.Second * 2
-
 var codecTypes = map[webrtc.RTPCodecType]string{
 	webrtc.RTPCodecTypeAudio: "audio",
 	webrtc.RTPCodecTypeVideo: "video",
@@ -27,6 +27,12 @@ var PeerConfig = webrtc.Configuration{
 	},
 }
 
+// listenTrack is a publisher track sent to the client by the listen peer
+type listenTrack struct {
+	source *webrtc.Track
+	sender *webrtc.RTPSender
+}
+
 type Connector struct {
 	*MessageBroker
 
@@ -34,6 +40,13 @@ type Connector struct {
 	closeOnce sync.Once
 	clientID  string
 
+	// publishOnly connectors only provide tracks to the room and never listen to it
+	publishOnly bool
+	// iceRestarts connectors survive ICE disconnects, the client recovers them with an ICE restart
+	iceRestarts bool
+	// audioLevelID is the negotiated id of the ssrc-audio-level extension, 0 when it was not negotiated
+	audioLevelID uint32
+
 	broadCastPeer *webrtc.PeerConnection
 	listenPeer    *webrtc.PeerConnection
 
@@ -41,21 +54,54 @@ type Connector struct {
 	renegotiates chan struct{}
 	closes       chan struct{}
 
-	listenTracks map[string][]*webrtc.RTPSender
+	// negotiateMux serializes the offers and answers of the listen peer. The changes made while an offer is
+	// outstanding are offered again once it is answered, a client offer in glare is requested again afterwards.
+	negotiateMux       sync.Mutex
+	offerPending       bool
+	renegotiatePending bool
+
+	listenMux    sync.Mutex
+	listenTracks map[string][]listenTrack
 	localTracks  []*webrtc.Track
+	subscription *subscription
+
+	sinksMux sync.RWMutex
+	sinks    map[string]TrackSink
+	// failedSinks are skipped after their first error until they are removed or replaced
+	failedSinks map[string]bool
+
+	keyframeMux      sync.Mutex
+	keyframeRequests map[uint32]time.Time
+	// screenSSRCs are the tracks of screen shares, their keyframes are requested less often
+	screenSSRCs map[uint32]bool
+
+	buffersMux sync.RWMutex
+	buffers    map[uint32]*packetBuffer
+
+	forwardersMux sync.RWMutex
+	forwarders    map[string]*forwarder
+	remoteTracks  int
 }
 
-func NewConnector(clientId string) (*Connector, error) {
+func NewConnector(clientId string, policy CodecPolicy) (*Connector, error) {
 	connector := &Connector{
 		clientID:     clientId,
 		localTracks:  make([]*webrtc.Track, 0),
 		signals:      make(chan Payload),
 		renegotiates: make(chan struct{}),
 		closes:       make(chan struct{}),
-		listenTracks: make(map[string][]*webrtc.RTPSender),
+		listenTracks: make(map[string][]listenTrack),
+		subscription: newSubscription(),
+		sinks:        make(map[string]TrackSink),
+		failedSinks:  make(map[string]bool),
+
+		keyframeRequests: make(map[uint32]time.Time),
+		screenSSRCs:      make(map[uint32]bool),
+		buffers:          make(map[uint32]*packetBuffer),
+		forwarders:       make(map[string]*forwarder),
 	}
 
-	if err := connector.initBroadCastPeer(); err != nil {
+	if err := connector.initBroadCastPeer(policy); err != nil {
 		return nil, fmt.Errorf("failed to init broadCast peer connection: %s", err.Error())
 	}
 
@@ -64,6 +110,19 @@ func NewConnector(clientId string) (*Connector, error) {
 	return connector, nil
 }
 
+// NewPublishConnector creates a publish-only Connector with a broadcast peer, it is used by WHIP clients.
+// Every received track is fanned out to the room as soon as it arrives.
+func NewPublishConnector(clientId string, policy CodecPolicy) (*Connector, error) {
+	connector, err := NewConnector(clientId, policy)
+	if err != nil {
+		return nil, err
+	}
+	connector.publishOnly = true
+	connector.iceRestarts = true
+
+	return connector, nil
+}
+
 func (c *Connector) Signals() <-chan Payload {
 	return c.signals
 }
@@ -84,30 +143,29 @@ func (c *Connector) ClientID() string {
 	return c.clientID
 }
 
-func (c *Connector) AddListenTracks(clientId string, sender *webrtc.RTPSender) {
-	c.mux.Lock()
-	defer c.mux.Unlock()
+func (c *Connector) AudioLevelExtensionID() uint8 {
+	return uint8(atomic.LoadUint32(&c.audioLevelID))
+}
 
-	senders, ok := c.listenTracks[clientId]
-	if !ok {
-		senders = make([]*webrtc.RTPSender, 0)
-	}
-	senders = append(senders, sender)
-	c.listenTracks[clientId] = senders
+func (c *Connector) AddListenTracks(clientId string, source *webrtc.Track, sender *webrtc.RTPSender) {
+	c.listenMux.Lock()
+	defer c.listenMux.Unlock()
+
+	c.listenTracks[clientId] = append(c.listenTracks[clientId], listenTrack{source: source, sender: sender})
 }
 
 func (c *Connector) RemoveListenTracks(clientId string) error {
-	c.mux.Lock()
-	defer c.mux.Unlock()
+	c.listenMux.Lock()
+	defer c.listenMux.Unlock()
 
-	senders, ok := c.listenTracks[clientId]
+	tracks, ok := c.listenTracks[clientId]
 	if !ok {
 		return fmt.Errorf("listen tracks were not found: %s", clientId)
 	}
 
 	var err error
-	for index := range senders {
-		if err = c.listenPeer.RemoveTrack(senders[index]); err != nil {
+	for index := range tracks {
+		if err = c.listenPeer.RemoveTrack(tracks[index].sender); err != nil {
 			return fmt.Errorf("unable to remove listen sender: %s", clientId)
 		}
 	}
@@ -117,41 +175,210 @@ func (c *Connector) RemoveListenTracks(clientId string) error {
 	return nil
 }
 
+// listenSender returns the sender of a publisher track on the listen peer, nil when the track is not sent
+func (c *Connector) listenSender(clientId string, source *webrtc.Track) *webrtc.RTPSender {
+	c.listenMux.Lock()
+	defer c.listenMux.Unlock()
+
+	for _, track := range c.listenTracks[clientId] {
+		if track.source == source {
+			return track.sender
+		}
+	}
+	return nil
+}
+
+// removeListenTrack stops sending one publisher track on the listen peer
+func (c *Connector) removeListenTrack(clientId string, source *webrtc.Track) error {
+	c.listenMux.Lock()
+	defer c.listenMux.Unlock()
+
+	tracks := c.listenTracks[clientId]
+	for index, track := range tracks {
+		if track.source != source {
+			continue
+		}
+		if err := c.listenPeer.RemoveTrack(track.sender); err != nil {
+			return fmt.Errorf("unable to remove listen sender: %w", err)
+		}
+		c.listenTracks[clientId] = append(tracks[:index], tracks[index+1:]...)
+		return nil
+	}
+	return nil
+}
+
+// listenTrackMap describes the tracks sent on the listen peer by their mid, so clients do not have to parse labels
+func (c *Connector) listenTrackMap() []track.Metadata {
+	c.listenMux.Lock()
+	defer c.listenMux.Unlock()
+
+	mids := map[*webrtc.RTPSender]string{}
+	if c.listenPeer != nil {
+		for _, transceiver := range c.listenPeer.GetTransceivers() {
+			if sender := transceiver.Sender(); sender != nil {
+				mids[sender] = transceiver.Mid()
+			}
+		}
+	}
+
+	tracks := make([]track.Metadata, 0)
+	for clientID, listenTracks := range c.listenTracks {
+		for _, sent := range listenTracks {
+			mid, ok := mids[sent.sender]
+			if !ok || mid == "" {
+				continue
+			}
+
+			info := track.NewTrackInfoFromWebRTC(sent.source)
+			info.Mid = mid
+			tracks = append(tracks, track.NewTrackMetadataFromTrack(info, clientID))
+		}
+	}
+	return tracks
+}
+
+// ListenPeer is the peer which sends the room to the client, nil before the first renegotiation
+func (c *Connector) ListenPeer() *webrtc.PeerConnection {
+	c.listenMux.Lock()
+	defer c.listenMux.Unlock()
+	return c.listenPeer
+}
+
+// replaceListenPeer starts over with a new listen peer, the client created a new peer connection for it.
+// It returns the tracks of the previous peer, the writers of the ones which are not sent again have to be stopped.
+func (c *Connector) replaceListenPeer(peerConnection *webrtc.PeerConnection) map[string][]listenTrack {
+	// The new peer is negotiated from scratch, nothing waits for the previous one anymore
+	c.negotiateMux.Lock()
+	c.offerPending, c.renegotiatePending = false, false
+	c.negotiateMux.Unlock()
+
+	c.listenMux.Lock()
+	previous, previousTracks := c.listenPeer, c.listenTracks
+	c.listenPeer = peerConnection
+	c.listenTracks = make(map[string][]listenTrack)
+	c.listenMux.Unlock()
+
+	if previous != nil {
+		if err := previous.Close(); err != nil {
+			log.Printf("[%s] unable to close previous listen peer: %s", c.clientID, err)
+		}
+	}
+	return previousTracks
+}
+
+// reusesListenPeer reports whether the offer renegotiates the current listen peer,
+// the client keeps its ICE credentials as long as it keeps its peer connection
+func (c *Connector) reusesListenPeer(offer webrtc.SessionDescription) bool {
+	peer := c.ListenPeer()
+	if peer == nil || peer.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return false
+	}
+
+	remote := peer.RemoteDescription()
+	if remote == nil {
+		return false
+	}
+
+	ufrag := iceUfrag(offer.SDP)
+	return ufrag != "" && ufrag == iceUfrag(remote.SDP)
+}
+
 func (c *Connector) HandleBroadcastRemoteOffer(sessionDescription webrtc.SessionDescription) (err error) {
+	answer, err := c.AnswerBroadcastOffer(sessionDescription)
+	if err != nil {
+		return err
+	}
+
+	c.sendSignal(NewSDPPayload(answer, c.clientID))
+	c.RenegotiateRequest()
+
+	return nil
+}
+
+// AnswerBroadcastOffer applies the offer to the broadcast peer and returns the answer with the gathered candidates
+func (c *Connector) AnswerBroadcastOffer(sessionDescription webrtc.SessionDescription) (webrtc.SessionDescription, error) {
 	if c.broadCastPeer.ICEConnectionState() == webrtc.ICEConnectionStateConnected {
-		return errors.New("broadCastPeer already in connected state")
+		return webrtc.SessionDescription{}, errors.New("broadCastPeer already in connected state")
 	}
 
-	if err = c.broadCastPeer.SetRemoteDescription(sessionDescription); err != nil {
-		return fmt.Errorf("error setting remote description: %w", err)
+	return c.answerBroadcast(sessionDescription)
+}
+
+// AddBroadcastICECandidate adds a trickled remote candidate to the broadcast peer
+func (c *Connector) AddBroadcastICECandidate(candidate webrtc.ICECandidateInit) error {
+	if err := c.broadCastPeer.AddICECandidate(candidate); err != nil {
+		return fmt.Errorf("error adding ice candidate: %w", err)
+	}
+	return nil
+}
+
+// RestartBroadcastICE renegotiates the broadcast peer with new remote ICE credentials,
+// the returned answer carries the new local credentials and candidates
+func (c *Connector) RestartBroadcastICE(ufrag, pwd string) (webrtc.SessionDescription, error) {
+	remote := c.broadCastPeer.RemoteDescription()
+	if remote == nil {
+		return webrtc.SessionDescription{}, errors.New("broadCastPeer has no remote description")
+	}
+
+	return c.answerBroadcast(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  replaceICECredentials(remote.SDP, ufrag, pwd),
+	})
+}
+
+func (c *Connector) answerBroadcast(sessionDescription webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if err := c.broadCastPeer.SetRemoteDescription(sessionDescription); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("error setting remote description: %w", err)
 	}
+	atomic.StoreUint32(&c.audioLevelID, uint32(extensionID(c.broadCastPeer.RemoteDescription(), webrtc.RTPCodecTypeAudio, AudioLevelURI)))
 
 	answer, err := c.broadCastPeer.CreateAnswer(nil)
 	if err != nil {
-		return fmt.Errorf("error creating answer: %w", err)
+		return webrtc.SessionDescription{}, fmt.Errorf("error creating answer: %w", err)
 	}
 
 	//TODO: should be changed on ICECandidateExchange
 	gatherComplete := webrtc.GatheringCompletePromise(c.broadCastPeer)
 
 	if err := c.broadCastPeer.SetLocalDescription(answer); err != nil {
-		return fmt.Errorf("error setting local description: %w", err)
+		return webrtc.SessionDescription{}, fmt.Errorf("error setting local description: %w", err)
 	}
 
 	<-gatherComplete
 
-	c.sendSignal(NewSDPPayload(answer, c.clientID))
-	c.RenegotiateRequest()
-
-	return nil
+	if local := c.broadCastPeer.LocalDescription(); local != nil {
+		return *local, nil
+	}
+	return answer, nil
 }
 
 func (c *Connector) RenegotiateRequest() {
+	if c.publishOnly {
+		return
+	}
 	c.sendSignal(NewRenegotiatePayload(c.clientID))
 }
 
+func (c *Connector) PublishOnly() bool {
+	return c.publishOnly
+}
+
 func (c *Connector) HandleListenRemoteOffer(peerConnection *webrtc.PeerConnection, sessionDescription webrtc.SessionDescription) (err error) {
+	c.negotiateMux.Lock()
+	defer c.negotiateMux.Unlock()
+
+	// Glare, the offer of the server wins since the listen peer can not roll it back. The client answers it
+	// and is asked to renegotiate afterwards, the tracks synced for this offer are offered by the server then.
+	if peerConnection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		log.Printf("[%s] listen offer in glare, it is requested again after the answer", c.clientID)
+		c.offerPending, c.renegotiatePending = true, true
+		return nil
+	}
+
+	c.listenMux.Lock()
 	c.listenPeer = peerConnection
+	c.listenMux.Unlock()
+
 	if err = c.listenPeer.SetRemoteDescription(sessionDescription); err != nil {
 		return fmt.Errorf("error setting remote description: %w", err)
 	}
@@ -170,32 +397,150 @@ func (c *Connector) HandleListenRemoteOffer(peerConnection *webrtc.PeerConnectio
 
 	<-gatherComplete
 
-	c.signals <- NewSDPPayloadWithRenegotiate(answer, c.clientID)
+	c.signals <- NewListenSDPPayload(answer, c.clientID, c.listenTrackMap())
 	return nil
 }
 
-func (c *Connector) OnTrackHandler() func(remoteTrack *webrtc.Track, receiver *webrtc.RTPReceiver) {
-	return func(remoteTrack *webrtc.Track, receiver *webrtc.RTPReceiver) {
-		go c.runPLISender(remoteTrack)
+// OfferListenPeer renegotiates the current listen peer from the server side, the client answers with a renegotiate signal.
+// While a previous offer is not answered the change is offered after the answer.
+func (c *Connector) OfferListenPeer() error {
+	c.negotiateMux.Lock()
+	defer c.negotiateMux.Unlock()
+
+	return c.offerListenPeer()
+}
+
+// offerListenPeer has to be called with negotiateMux held
+func (c *Connector) offerListenPeer() error {
+	peer := c.ListenPeer()
+	if peer == nil {
+		return errors.New("listenPeer is not negotiated")
+	}
+	if peer.SignalingState() != webrtc.SignalingStateStable {
+		c.offerPending = true
+		return nil
+	}
+	c.offerPending = false
+
+	offer, err := peer.CreateOffer(nil)
+	if err != nil {
+		return fmt.Errorf("error creating offer: %w", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(peer)
+
+	if err := peer.SetLocalDescription(offer); err != nil {
+		return fmt.Errorf("error setting local description: %w", err)
+	}
 
-		trackLabel := fmt.Sprintf("pion-%s-%s", codecTypes[remoteTrack.Kind()], c.ClientID())
+	<-gatherComplete
+
+	if local := peer.LocalDescription(); local != nil {
+		offer = *local
+	}
+	c.sendSignal(NewListenSDPPayload(offer, c.clientID, c.listenTrackMap()))
+	return nil
+}
+
+// HandleListenRemoteAnswer completes a renegotiation started by OfferListenPeer, the changes which waited for it
+// are offered next and a client offer lost in glare is requested after them
+func (c *Connector) HandleListenRemoteAnswer(sessionDescription webrtc.SessionDescription) error {
+	c.negotiateMux.Lock()
+	defer c.negotiateMux.Unlock()
+
+	peer := c.ListenPeer()
+	if peer == nil {
+		return errors.New("listenPeer is not negotiated")
+	}
+
+	if err := peer.SetRemoteDescription(sessionDescription); err != nil {
+		return fmt.Errorf("error setting remote description: %w", err)
+	}
 
-		localTrack, newTrackErr := c.broadCastPeer.NewTrack(remoteTrack.PayloadType(), remoteTrack.SSRC(), "video", trackLabel)
-		if newTrackErr != nil {
-			panic(newTrackErr)
+	switch {
+	case c.offerPending:
+		return c.offerListenPeer()
+	case c.renegotiatePending:
+		c.renegotiatePending = false
+		c.RenegotiateRequest()
+	}
+	return nil
+}
+
+// OnTrackHandler fans out the remote tracks, a track which can not be forwarded closes the connector,
+// the participant would be in the room without its media otherwise
+func (c *Connector) OnTrackHandler() func(remoteTrack *webrtc.Track, receiver *webrtc.RTPReceiver) {
+	return func(remoteTrack *webrtc.Track, receiver *webrtc.RTPReceiver) {
+		forwarder, err := c.forwarderFor(remoteTrack)
+		if err != nil {
+			log.Printf("[%s] unable to forward track %s, closing the connector: %s", c.clientID, remoteTrack.ID(), err)
+			if err = c.Close(); err != nil {
+				log.Printf("[%s] unable to close connector: %s", c.clientID, err)
+			}
+			return
 		}
 
-		c.localTracks = append(c.localTracks, localTrack)
-		if len(c.localTracks) == len(c.broadCastPeer.GetTransceivers()) {
+		c.forwardersMux.Lock()
+		c.remoteTracks++
+		received := c.remoteTracks
+		c.forwardersMux.Unlock()
+
+		// Publish-only clients may offer any set of media, fan out each track as it arrives
+		if c.publishOnly || received == len(c.broadCastPeer.GetTransceivers()) {
 			c.askAllNegotiation()
 		}
 
-		go c.transmitRTP(remoteTrack, localTrack)
+		go c.transmitRTP(remoteTrack, forwarder)
+	}
+}
+
+// forwarderFor creates the local track and the forwarder of a remote track
+func (c *Connector) forwarderFor(remoteTrack *webrtc.Track) (*forwarder, error) {
+	screen := isScreenShare(remoteTrack.ID())
+	id := fmt.Sprintf("ssrc-%d", remoteTrack.SSRC())
+
+	c.forwardersMux.Lock()
+	defer c.forwardersMux.Unlock()
+
+	localTrack, err := c.newLocalTrack(remoteTrack, screen)
+	if err != nil {
+		return nil, err
+	}
+	forwarder := newForwarder(id, c, localTrack, screen)
+	c.forwarders[id] = forwarder
+
+	if screen {
+		c.keyframeMux.Lock()
+		c.screenSSRCs[remoteTrack.SSRC()] = true
+		c.keyframeMux.Unlock()
+	}
+	return forwarder, nil
+}
+
+func (c *Connector) newLocalTrack(remoteTrack *webrtc.Track, screen bool) (*webrtc.Track, error) {
+	trackLabel := fmt.Sprintf("pion-%s-%s", codecTypes[remoteTrack.Kind()], c.ClientID())
+	switch {
+	case screen && remoteTrack.Kind() == webrtc.RTPCodecTypeVideo:
+		trackLabel = ScreenLabelPrefix + c.ClientID()
+	case screen:
+		trackLabel = fmt.Sprintf("%s%s-%s", ScreenLabelPrefix, codecTypes[remoteTrack.Kind()], c.ClientID())
+	}
+
+	localTrack, err := c.broadCastPeer.NewTrack(remoteTrack.PayloadType(), remoteTrack.SSRC(), "video", trackLabel)
+	if err != nil {
+		return nil, err
 	}
+
+	c.localTracks = append(c.localTracks, localTrack)
+	return localTrack, nil
 }
 
 func (c *Connector) ICEConnectionStateChangeHandler(connectionState webrtc.ICEConnectionState) {
 	log.Printf("Peer connection state changed: %s %s", c.clientID, connectionState.String())
+	if connectionState == webrtc.ICEConnectionStateDisconnected && c.iceRestarts {
+		return
+	}
+
 	if connectionState == webrtc.ICEConnectionStateClosed ||
 		connectionState == webrtc.ICEConnectionStateDisconnected ||
 		connectionState == webrtc.ICEConnectionStateFailed {
@@ -206,14 +551,17 @@ func (c *Connector) ICEConnectionStateChangeHandler(connectionState webrtc.ICECo
 	}
 }
 
-func (c *Connector) initBroadCastPeer() (err error) {
-	c.broadCastPeer, err = webrtc.NewPeerConnection(PeerConfig)
+func (c *Connector) initBroadCastPeer(policy CodecPolicy) (err error) {
+	c.broadCastPeer, err = newBroadCastAPI(policy).NewPeerConnection(PeerConfig)
 	if err != nil {
 		return fmt.Errorf("unable to create new webrtc.PeerConnection:%s", err.Error())
 	}
 
-	if _, err = c.broadCastPeer.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
-		return fmt.Errorf("unable to add video codec transceiver1:%s", err.Error())
+	// Rooms may be restricted to audio codecs
+	if policy.allowsKind(webrtc.RTPCodecTypeVideo) {
+		if _, err = c.broadCastPeer.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+			return fmt.Errorf("unable to add video codec transceiver1:%s", err.Error())
+		}
 	}
 
 	c.broadCastPeer.OnICEConnectionStateChange(c.ICEConnectionStateChangeHandler)
@@ -223,8 +571,8 @@ func (c *Connector) initBroadCastPeer() (err error) {
 }
 
 func (c *Connector) Close() error {
-	if c.listenPeer != nil {
-		if err := c.listenPeer.Close(); err != nil {
+	if listenPeer := c.ListenPeer(); listenPeer != nil {
+		if err := listenPeer.Close(); err != nil {
 			return fmt.Errorf("unable to close listen peer: %s", err.Error())
 		}
 	}
@@ -232,59 +580,72 @@ func (c *Connector) Close() error {
 	return c.closeBroadCast()
 }
 
-func (c *Connector) runPLISender(remote *webrtc.Track) {
-	ticker := time.NewTicker(PLISendInterval)
-	for range ticker.C {
-		if rtcpSendErr := c.broadCastPeer.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: remote.SSRC()}}); rtcpSendErr != nil {
-			log.Printf("[%s] failed to write PLI to broadcast peer", c.clientID)
-
-			if rtcpSendErr == io.ErrClosedPipe {
-				log.Printf("[%s] stop PLI sender,closed pipe: %s", c.clientID, rtcpSendErr.Error())
-			}
-			return
-		}
-	}
-}
-
-func (c Connector) transmitRTP(remote, local *webrtc.Track) {
+// transmitRTP reads a remote track into pooled packets and dispatches them to the subscribers through the forwarder
+func (c *Connector) transmitRTP(remote *webrtc.Track, forwarder *forwarder) {
+	// Only video is retransmitted, a late audio packet is useless
 	var (
-		rtpBuf = make([]byte, 1400)
-		err    error
+		buffer *packetBuffer
+		nacks  *nackGenerator
 	)
+	if remote.Kind() == webrtc.RTPCodecTypeVideo {
+		buffer = c.addPacketBuffer(remote.SSRC())
+		nacks = newNackGenerator()
+	}
+
 	for {
-		i, readErr := remote.Read(rtpBuf)
+		packet := getPacket()
+		i, readErr := remote.Read(packet.buf)
 		if readErr != nil {
+			packet.release()
 			log.Println("failed to read rtp from remote stream", readErr)
 			return
 		}
 
-		if _, err = local.Write(rtpBuf[:i]); err != nil && err != io.ErrClosedPipe {
-			log.Println("failed to write rtp to local stream", err)
-			return
+		if err := packet.unmarshal(i); err != nil {
+			packet.release()
+			log.Println("failed to unmarshal rtp from remote stream", err)
+			continue
+		}
+
+		if buffer != nil {
+			buffer.push(packet.packet.SequenceNumber, packet.buf[:i])
+
+			now := time.Now()
+			nacks.push(packet.packet.SequenceNumber, now)
+			if missing := nacks.due(now); len(missing) != 0 {
+				c.requestRetransmission(remote.SSRC(), missing)
+			}
+		}
+
+		if c.hasSinks() {
+			c.writeSinks(remote, &packet.packet)
 		}
+
+		forwarder.dispatch(packet)
+		packet.release()
 	}
 }
 
-func (c *Connector) closeBroadCast() error {
-	errs := make(chan error)
+func (c *Connector) closeBroadCast() (err error) {
 	c.closeOnce.Do(func() {
-		var err error
-
 		c.closes <- struct{}{}
 
+		c.closeSinks()
+		c.closeForwarders()
+		c.stopIncoming()
+
 		if c.broadCastPeer != nil {
-			if err := c.broadCastPeer.Close(); err != nil {
-				err = fmt.Errorf("unable to close broadCastPeer: %s", err.Error())
+			if closeErr := c.broadCastPeer.Close(); closeErr != nil {
+				err = fmt.Errorf("unable to close broadCastPeer: %s", closeErr.Error())
 			}
 		}
 
 		close(c.closes)
 		close(c.signals)
 		close(c.renegotiates)
-		errs <- err
 	})
 
-	return <-errs
+	return err
 }
 
 func (c *Connector) sendSignal(payload Payload) {
@@ -310,3 +671,27 @@ func (c *Connector) askAllNegotiation() {
 		}
 	}()
 }
+
+// iceUfrag returns the first ice-ufrag attribute of a session description
+func iceUfrag(sdp string) string {
+	for _, line := range strings.Split(sdp, "\r\n") {
+		if strings.HasPrefix(line, "a=ice-ufrag:") {
+			return strings.TrimPrefix(line, "a=ice-ufrag:")
+		}
+	}
+	return ""
+}
+
+// replaceICECredentials rewrites the session and media level ice-ufrag and ice-pwd attributes
+func replaceICECredentials(sdp, ufrag, pwd string) string {
+	lines := strings.Split(sdp, "\r\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "a=ice-ufrag:"):
+			lines[i] = "a=ice-ufrag:" + ufrag
+		case strings.HasPrefix(line, "a=ice-pwd:"):
+			lines[i] = "a=ice-pwd:" + pwd
+		}
+	}
+	return strings.Join(lines, "\r\n")
+}
@@ -0,0 +1,539 @@
+package webrtc
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Every remote track of a publisher is forwarded by a forwarder. Packets are read once into pooled buffers and parsed once,
+// the forwarder hands them to a writer per subscriber which rewrites the header for the track of its subscriber.
+// Publishers send one encoding per track, this pion version can not demultiplex rid based simulcast
+// and answers simulcast offers without simulcast.
+const (
+	// receiveMTU is the size of the pooled read buffers
+	receiveMTU = 1500
+	// writerQueueSize is how many packets a subscriber may lag behind, later packets are dropped and recovered by NACK
+	writerQueueSize = 128
+	// screenWriterQueueSize absorbs the large keyframes of screen shares
+	screenWriterQueueSize = 512
+	// screenBacklogLimit is how many screen share packets may wait for a subscriber before its camera video is dropped,
+	// the camera video continues on a keyframe once the screen share caught up
+	screenBacklogLimit = writerQueueSize
+)
+
+var (
+	packetPool = sync.Pool{
+		New: func() interface{} {
+			return &pooledPacket{buf: make([]byte, receiveMTU)}
+		},
+	}
+
+	screenBacklogs = &screenBacklogRegistry{backlogs: make(map[string]*screenBacklog)}
+)
+
+type (
+	// pooledPacket is shared by the writers of all subscribers, the last one to release it returns it to the pool
+	pooledPacket struct {
+		buf    []byte
+		packet rtp.Packet
+		refs   int32
+	}
+
+	forwarder struct {
+		mux sync.RWMutex
+
+		id        string
+		publisher *Connector
+		template  *webrtc.Track
+		// the camera video of all publishers gives way to screen shares, see screenBacklogLimit
+		screen bool
+		closed bool
+		// muted forwarders drop the packets of the publisher, sinks still receive them
+		muted bool
+
+		clockRate uint32
+		writers   map[string]*subscriberWriter
+	}
+
+	// subscriberWriter has its own track, so it can pause and continue on a keyframe without renegotiation.
+	// paused is guarded by the forwarder lock. dispatch changes the forwarding state under the read lock,
+	// so it also holds mux for it, like everybody else who reads that state under the read lock.
+	// out is owned by the writer goroutine.
+	subscriberWriter struct {
+		track  *webrtc.Track
+		sender *webrtc.RTPSender
+		queue  chan forwardedPacket
+		mux    sync.Mutex
+
+		// paused writers receive nothing until they are resumed on the next keyframe
+		paused bool
+		// forwarding is false until the writer got a keyframe after it was created, resumed or unmuted
+		forwarding bool
+		// backlog is shared with the other video writers of the subscriber, screen writers count their queued packets.
+		// Audio writers have none.
+		backlog *screenBacklog
+		screen  bool
+		// yielded camera writers dropped packets for a screen share backlog, they ask for a keyframe once it is gone
+		yielded bool
+
+		started    bool
+		seqOffset  uint16
+		tsOffset   uint32
+		lastSeq    uint16
+		lastTS     uint32
+		lastSentAt time.Time
+
+		out rtp.Packet
+	}
+
+	// screenBacklog counts the screen share packets queued for one subscriber, it is shared by the video writers
+	// of all publishers to the subscriber so camera video gives way to screen shares
+	screenBacklog struct {
+		queued int32
+		refs   int
+	}
+
+	screenBacklogRegistry struct {
+		mux      sync.Mutex
+		backlogs map[string]*screenBacklog
+	}
+
+	// forwardedPacket carries the rewritten sequence number and timestamp, the ssrc is the one of the writer track
+	forwardedPacket struct {
+		packet *pooledPacket
+		seq    uint16
+		ts     uint32
+	}
+)
+
+// acquire returns the backlog of the subscriber, it is shared until every writer released it
+func (r *screenBacklogRegistry) acquire(subscriberID string) *screenBacklog {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	backlog, ok := r.backlogs[subscriberID]
+	if !ok {
+		backlog = &screenBacklog{}
+		r.backlogs[subscriberID] = backlog
+	}
+	backlog.refs++
+	return backlog
+}
+
+func (r *screenBacklogRegistry) release(subscriberID string, backlog *screenBacklog) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	backlog.refs--
+	if backlog.refs == 0 && r.backlogs[subscriberID] == backlog {
+		delete(r.backlogs, subscriberID)
+	}
+}
+
+func (b *screenBacklog) congested() bool {
+	return atomic.LoadInt32(&b.queued) >= screenBacklogLimit
+}
+
+func getPacket() *pooledPacket {
+	packet := packetPool.Get().(*pooledPacket)
+	packet.refs = 1
+	return packet
+}
+
+// unmarshal parses the first n bytes of the buffer, the extensions slice of the previous packet is reused
+func (p *pooledPacket) unmarshal(n int) error {
+	p.packet.Extensions = p.packet.Extensions[:0]
+	return p.packet.Unmarshal(p.buf[:n])
+}
+
+func (p *pooledPacket) retain() {
+	atomic.AddInt32(&p.refs, 1)
+}
+
+func (p *pooledPacket) release() {
+	if atomic.AddInt32(&p.refs, -1) == 0 {
+		packetPool.Put(p)
+	}
+}
+
+// newForwarder forwards a remote track through template, the local track which has the ssrc of the remote one
+func newForwarder(id string, publisher *Connector, template *webrtc.Track, screen bool) *forwarder {
+	var clockRate uint32 = 90000
+	if codec := template.Codec(); codec != nil {
+		clockRate = codec.ClockRate
+	}
+
+	return &forwarder{
+		id:        id,
+		publisher: publisher,
+		template:  template,
+		screen:    screen,
+		clockRate: clockRate,
+		writers:   make(map[string]*subscriberWriter),
+	}
+}
+
+// subscribe creates the track of the subscriber, it replaces the previous subscription of the same subscriber
+func (f *forwarder) subscribe(subscriberID string) (*webrtc.Track, error) {
+	track, err := webrtc.NewTrack(f.template.PayloadType(), rand.Uint32(), f.template.ID(), f.template.Label(), f.template.Codec())
+	if err != nil {
+		return nil, err
+	}
+
+	f.subscribeTrack(subscriberID, track, nil)
+	return track, nil
+}
+
+// subscribeTrack forwards to a track of the subscriber, e.g. a track a viewer reserved before the publisher joined.
+// The packets continue the sequence numbers and timestamps of the previous writer of the track, if any.
+func (f *forwarder) subscribeTrack(subscriberID string, track *webrtc.Track, previous *subscriberWriter) *subscriberWriter {
+	queueSize := writerQueueSize
+	if f.screen {
+		queueSize = screenWriterQueueSize
+	}
+	writer := &subscriberWriter{track: track, queue: make(chan forwardedPacket, queueSize)}
+	if f.template.Kind() == webrtc.RTPCodecTypeVideo {
+		writer.backlog, writer.screen = screenBacklogs.acquire(subscriberID), f.screen
+	}
+	if previous != nil {
+		previous.mux.Lock()
+		writer.started, writer.lastSeq, writer.lastTS, writer.lastSentAt = previous.started, previous.lastSeq, previous.lastTS, previous.lastSentAt
+		previous.mux.Unlock()
+	}
+
+	f.mux.Lock()
+	if f.closed {
+		// The publisher is gone, the track stays silent
+		close(writer.queue)
+	} else {
+		if previous, ok := f.writers[subscriberID]; ok {
+			close(previous.queue)
+		}
+		f.writers[subscriberID] = writer
+		// The writer starts on the next keyframe
+		f.requestKeyframe()
+	}
+	f.mux.Unlock()
+
+	go writer.run(f.publisher.ClientID(), subscriberID)
+	return writer
+}
+
+func (f *forwarder) setSender(subscriberID string, sender *webrtc.RTPSender) {
+	f.mux.Lock()
+	if writer, ok := f.writers[subscriberID]; ok {
+		writer.sender = sender
+	}
+	f.mux.Unlock()
+}
+
+func (f *forwarder) unsubscribe(subscriberID string) {
+	f.mux.Lock()
+	if writer, ok := f.writers[subscriberID]; ok {
+		close(writer.queue)
+		delete(f.writers, subscriberID)
+	}
+	f.mux.Unlock()
+}
+
+// close stops the writers once the publisher is gone
+func (f *forwarder) close() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.closed = true
+	for subscriberID, writer := range f.writers {
+		close(writer.queue)
+		delete(f.writers, subscriberID)
+	}
+}
+
+// setPaused stops or resumes forwarding to one subscriber without renegotiation,
+// a resumed subscriber continues the sequence numbers and timestamps from the next keyframe
+func (f *forwarder) setPaused(subscriberID string, paused bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	writer, ok := f.writers[subscriberID]
+	if !ok || writer.paused == paused {
+		return
+	}
+
+	writer.paused = paused
+	writer.mux.Lock()
+	writer.forwarding = false
+	writer.mux.Unlock()
+	if !paused {
+		f.requestKeyframe()
+	}
+}
+
+// setMuted stops or resumes forwarding to all subscribers, they continue from the next keyframe after unmute
+func (f *forwarder) setMuted(muted bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if f.muted == muted {
+		return
+	}
+	f.muted = muted
+	if muted {
+		return
+	}
+
+	for _, writer := range f.writers {
+		writer.mux.Lock()
+		writer.forwarding = false
+		writer.mux.Unlock()
+	}
+	f.requestKeyframe()
+}
+
+func (f *forwarder) isMuted() bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	return f.muted
+}
+
+// sourceSSRC reports whether ssrc is the one of a subscriber track and maps it to the ssrc of the publisher
+func (f *forwarder) sourceSSRC(ssrc uint32) (uint32, bool) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	for _, writer := range f.writers {
+		if writer.track.SSRC() == ssrc {
+			return f.template.SSRC(), true
+		}
+	}
+	return 0, false
+}
+
+// requestKeyframe asks the publisher for a keyframe of a video track, audio needs none
+func (f *forwarder) requestKeyframe() {
+	if f.template.Kind() == webrtc.RTPCodecTypeVideo {
+		f.publisher.RequestKeyframe(f.template.SSRC())
+	}
+}
+
+// dispatch hands a packet to the writers, the packet is retained once per writer.
+// It runs for every packet of the track, so it only takes the read lock and the lock of each writer it forwards to.
+func (f *forwarder) dispatch(packet *pooledPacket) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	if f.closed || f.muted {
+		return
+	}
+
+	now := time.Now()
+	keyframe, detected := false, false
+	for _, writer := range f.writers {
+		if writer.paused {
+			continue
+		}
+
+		writer.mux.Lock()
+		if writer.backlog != nil && !writer.screen {
+			// Camera video gives way while the screen shares to the subscriber lag behind
+			if writer.backlog.congested() {
+				writer.forwarding, writer.yielded = false, true
+				writer.mux.Unlock()
+				continue
+			}
+			if writer.yielded {
+				writer.yielded = false
+				f.requestKeyframe()
+			}
+		}
+		if !writer.forwarding {
+			if !detected {
+				keyframe, detected = isKeyframe(f.template.Codec(), packet.packet.Payload), true
+			}
+			if !keyframe {
+				writer.mux.Unlock()
+				continue
+			}
+			writer.resume(&packet.packet.Header, f.clockRate, now)
+		}
+
+		seq := packet.packet.SequenceNumber + writer.seqOffset
+		ts := packet.packet.Timestamp + writer.tsOffset
+		writer.lastSeq, writer.lastTS, writer.lastSentAt = seq, ts, now
+		writer.mux.Unlock()
+
+		packet.retain()
+		select {
+		case writer.queue <- forwardedPacket{packet: packet, seq: seq, ts: ts}:
+			if writer.screen {
+				atomic.AddInt32(&writer.backlog.queued, 1)
+			}
+		default:
+			packet.release()
+		}
+	}
+}
+
+// retransmit answers the NACK of a subscriber from the buffer of the track,
+// the sequence numbers are mapped back with the offset of the writer
+func (f *forwarder) retransmit(sender *webrtc.RTPSender, nack *rtcp.TransportLayerNack) {
+	f.mux.RLock()
+	var writer *subscriberWriter
+	for _, candidate := range f.writers {
+		if candidate.sender == sender {
+			writer = candidate
+			break
+		}
+	}
+	if writer == nil {
+		f.mux.RUnlock()
+		return
+	}
+	writer.mux.Lock()
+	started, ssrc, seqOffset, tsOffset := writer.started, writer.track.SSRC(), writer.seqOffset, writer.tsOffset
+	writer.mux.Unlock()
+	f.mux.RUnlock()
+
+	if !started {
+		return
+	}
+
+	buffer, ok := f.publisher.packetBuffer(f.template.SSRC())
+	if !ok {
+		return
+	}
+
+	for _, pair := range nack.Nacks {
+		for _, seq := range pair.PacketList() {
+			packet, ok := buffer.get(seq - seqOffset)
+			if !ok {
+				continue
+			}
+			packet.SSRC = ssrc
+			packet.SequenceNumber = seq
+			packet.Timestamp += tsOffset
+			if _, err := sender.SendRTP(&packet.Header, packet.Payload); err != nil {
+				if err != io.ErrClosedPipe {
+					log.Printf("[%s] failed to retransmit rtp: %s", f.publisher.ClientID(), err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// resume rewrites the sequence numbers and timestamps from the keyframe on to continue the packets sent before,
+// so the subscriber sees no gap after a pause or a change of the publisher of its track
+func (w *subscriberWriter) resume(header *rtp.Header, clockRate uint32, now time.Time) {
+	if w.started {
+		w.seqOffset = w.lastSeq + 1 - header.SequenceNumber
+		elapsed := uint32(now.Sub(w.lastSentAt).Seconds()*float64(clockRate)) + 1
+		w.tsOffset = w.lastTS + elapsed - header.Timestamp
+	}
+	w.started = true
+	w.forwarding = true
+}
+
+// run writes the queued packets to the subscriber track until the writer is unsubscribed
+func (w *subscriberWriter) run(publisherID, subscriberID string) {
+	if w.backlog != nil {
+		defer screenBacklogs.release(subscriberID, w.backlog)
+	}
+
+	for forwarded := range w.queue {
+		w.out.Header = forwarded.packet.packet.Header
+		w.out.SSRC = w.track.SSRC()
+		w.out.SequenceNumber = forwarded.seq
+		w.out.Timestamp = forwarded.ts
+		w.out.Payload = forwarded.packet.packet.Payload
+
+		err := w.track.WriteRTP(&w.out)
+		forwarded.packet.release()
+		if w.screen {
+			atomic.AddInt32(&w.backlog.queued, -1)
+		}
+		if err != nil && err != io.ErrClosedPipe {
+			log.Printf("[%s] failed to write rtp to subscriber track: %s", publisherID, err)
+		}
+	}
+}
+
+// forwarderOf returns the forwarder of a local track, nil for the tracks of static connectors
+func (c *Connector) forwarderOf(local *webrtc.Track) *forwarder {
+	c.forwardersMux.RLock()
+	defer c.forwardersMux.RUnlock()
+
+	for _, forwarder := range c.forwarders {
+		if forwarder.template == local {
+			return forwarder
+		}
+	}
+	return nil
+}
+
+func (c *Connector) forwarderList() []*forwarder {
+	c.forwardersMux.RLock()
+	defer c.forwardersMux.RUnlock()
+
+	forwarders := make([]*forwarder, 0, len(c.forwarders))
+	for _, forwarder := range c.forwarders {
+		forwarders = append(forwarders, forwarder)
+	}
+	return forwarders
+}
+
+// subscriberTrack returns the track a subscriber receives for a local track,
+// forwarders create a track per subscriber, bind has to be called with its sender
+func (c *Connector) subscriberTrack(local *webrtc.Track, subscriberID string) (track *webrtc.Track, bind func(*webrtc.RTPSender), err error) {
+	forwarder := c.forwarderOf(local)
+	if forwarder == nil {
+		return local, func(*webrtc.RTPSender) {}, nil
+	}
+
+	if track, err = forwarder.subscribe(subscriberID); err != nil {
+		return nil, nil, fmt.Errorf("unable to create subscriber track: %w", err)
+	}
+	return track, func(sender *webrtc.RTPSender) {
+		forwarder.setSender(subscriberID, sender)
+	}, nil
+}
+
+// unsubscribeTracks stops the writers of the subscriber for every track of the publisher
+func (c *Connector) unsubscribeTracks(subscriberID string) {
+	for _, forwarder := range c.forwarderList() {
+		forwarder.unsubscribe(subscriberID)
+	}
+}
+
+// sourceSSRC maps the ssrc a subscriber knows to the ssrc of the publisher, forwarder is nil for tracks which are not rewritten
+func (c *Connector) sourceSSRC(ssrc uint32) (source uint32, forwarder *forwarder) {
+	for _, forwarder := range c.forwarderList() {
+		if source, ok := forwarder.sourceSSRC(ssrc); ok {
+			return source, forwarder
+		}
+	}
+	return ssrc, nil
+}
+
+// pauseVideo stops or resumes the video of the publisher for one subscriber
+func (c *Connector) pauseVideo(subscriberID string, paused bool) {
+	for _, forwarder := range c.forwarderList() {
+		// Screen shares are forwarded to everybody regardless of the Last-N
+		if forwarder.template.Kind() == webrtc.RTPCodecTypeVideo && !forwarder.screen {
+			forwarder.setPaused(subscriberID, paused)
+		}
+	}
+}
+
+func (c *Connector) closeForwarders() {
+	for _, forwarder := range c.forwarderList() {
+		forwarder.close()
+	}
+}
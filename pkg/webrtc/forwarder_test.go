@@ -0,0 +1,185 @@
+package webrtc
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// newTestForwarder forwards a VP8 track, its publisher is a static connector which has no peer connection to ask for keyframes
+func newTestForwarder(tb testing.TB) *forwarder {
+	template, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, 1, "camera", "publisher", webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return newForwarder("camera", &Connector{clientID: "publisher"}, template, false)
+}
+
+// vp8Packet marshals a VP8 packet, keyframe tells the first payload descriptor bit of the frame
+func vp8Packet(tb testing.TB, seq uint16, keyframe bool) []byte {
+	frame := byte(0x01)
+	if keyframe {
+		frame = 0x00
+	}
+	raw, err := (&rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: webrtc.DefaultPayloadTypeVP8, SSRC: 1, SequenceNumber: seq, Timestamp: uint32(seq) * 3000},
+		Payload: append([]byte{0x10, frame}, make([]byte, 1000)...),
+	}).Marshal()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return raw
+}
+
+func dispatchRaw(tb testing.TB, f *forwarder, raw []byte) {
+	packet := getPacket()
+	n := copy(packet.buf, raw)
+	if err := packet.unmarshal(n); err != nil {
+		tb.Fatal(err)
+	}
+	f.dispatch(packet)
+	packet.release()
+}
+
+func TestForwarderWriterState(t *testing.T) {
+	type step struct {
+		pause    bool
+		resume   bool
+		seq      uint16
+		keyframe bool
+	}
+
+	tests := []struct {
+		name string
+		// steps either pause or resume the writer or dispatch a packet
+		steps []step
+		// sent is the sequence number the subscriber saw last, 0 when it got nothing
+		sent uint16
+	}{
+		{"waits for a keyframe", []step{{seq: 10}, {seq: 11}}, 0},
+		{"starts on a keyframe", []step{{seq: 10}, {seq: 11, keyframe: true}, {seq: 12}}, 12},
+		{"paused", []step{{seq: 10, keyframe: true}, {pause: true}, {seq: 11, keyframe: true}}, 10},
+		// The packets sent while paused are skipped, the subscriber sees no gap
+		{"resumed on a keyframe", []step{{seq: 10, keyframe: true}, {pause: true}, {seq: 11}, {resume: true}, {seq: 12}, {seq: 13, keyframe: true}}, 11},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newTestForwarder(t)
+			defer f.close()
+			if _, err := f.subscribe("subscriber"); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, step := range test.steps {
+				switch {
+				case step.pause:
+					f.setPaused("subscriber", true)
+				case step.resume:
+					f.setPaused("subscriber", false)
+				default:
+					dispatchRaw(t, f, vp8Packet(t, step.seq, step.keyframe))
+				}
+			}
+
+			f.mux.RLock()
+			writer := f.writers["subscriber"]
+			f.mux.RUnlock()
+			writer.mux.Lock()
+			started, sent := writer.started, writer.lastSeq
+			writer.mux.Unlock()
+
+			if test.sent == 0 && started {
+				t.Fatalf("expected nothing to be sent, got %d", sent)
+			}
+			if test.sent != 0 && sent != test.sent {
+				t.Fatalf("expected %d to be sent last, got %d", test.sent, sent)
+			}
+		})
+	}
+}
+
+func TestForwarderScreenPriority(t *testing.T) {
+	camera := newTestForwarder(t)
+	screen := newTestForwarder(t)
+	screen.screen = true
+	for _, f := range []*forwarder{camera, screen} {
+		if _, err := f.subscribe("subscriber"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backlog := screenBacklogs.acquire("subscriber")
+	sent := func() (bool, uint16) {
+		camera.mux.RLock()
+		writer := camera.writers["subscriber"]
+		camera.mux.RUnlock()
+		writer.mux.Lock()
+		defer writer.mux.Unlock()
+		return writer.started, writer.lastSeq
+	}
+
+	// The screen share lags behind, the camera keyframe is dropped
+	atomic.StoreInt32(&backlog.queued, screenBacklogLimit)
+	dispatchRaw(t, camera, vp8Packet(t, 10, true))
+	if started, seq := sent(); started {
+		t.Fatalf("expected the camera video to give way, got %d", seq)
+	}
+
+	// Caught up, the camera continues on the next keyframe
+	atomic.StoreInt32(&backlog.queued, 0)
+	dispatchRaw(t, camera, vp8Packet(t, 11, false))
+	dispatchRaw(t, camera, vp8Packet(t, 12, true))
+	if started, seq := sent(); !started || seq != 12 {
+		t.Fatalf("expected the camera video to continue with 12, got %v, %d", started, seq)
+	}
+
+	camera.close()
+	screen.close()
+	screenBacklogs.release("subscriber", backlog)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		screenBacklogs.mux.Lock()
+		_, ok := screenBacklogs.backlogs["subscriber"]
+		screenBacklogs.mux.Unlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the backlog to be released with the writers")
+		}
+	}
+}
+
+func BenchmarkForwarderDispatch(b *testing.B) {
+	for _, writers := range []int{1, 500} {
+		b.Run(fmt.Sprintf("writers=%d", writers), func(b *testing.B) {
+			f := newTestForwarder(b)
+			for i := 0; i < writers; i++ {
+				if _, err := f.subscribe(fmt.Sprintf("subscriber-%d", i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			defer f.close()
+
+			// A VP8 keyframe, so the writers start with the first packet
+			raw := vp8Packet(b, 0, true)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				packet := getPacket()
+				n := copy(packet.buf, raw)
+				if err := packet.unmarshal(n); err != nil {
+					b.Fatal(err)
+				}
+				packet.packet.SequenceNumber = uint16(i)
+				f.dispatch(packet)
+				packet.release()
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package webrtc
+
+import (
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// KeyframeRequestInterval is the minimum time between two keyframe requests for the same publisher track
+	KeyframeRequestInterval = 500 * time.Millisecond
+	// ScreenKeyframeRequestInterval applies to screen shares, their content is detailed and mostly static,
+	// so keyframes are large and lost packets are better recovered by NACK than by a new keyframe
+	ScreenKeyframeRequestInterval = 2 * time.Second
+)
+
+// RequestKeyframe sends a PLI for the track to the publisher, requests within KeyframeRequestInterval
+// or ScreenKeyframeRequestInterval are dropped
+func (c *Connector) RequestKeyframe(ssrc uint32) {
+	if c.broadCastPeer == nil {
+		// Static connectors replay files, there is nobody to ask for a keyframe
+		return
+	}
+
+	c.keyframeMux.Lock()
+	interval := KeyframeRequestInterval
+	if c.screenSSRCs[ssrc] {
+		interval = ScreenKeyframeRequestInterval
+	}
+	if time.Since(c.keyframeRequests[ssrc]) < interval {
+		c.keyframeMux.Unlock()
+		return
+	}
+	c.keyframeRequests[ssrc] = time.Now()
+	c.keyframeMux.Unlock()
+
+	if err := c.broadCastPeer.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}}); err != nil && err != io.ErrClosedPipe {
+		log.Printf("[%s] failed to write PLI to broadcast peer: %s", c.clientID, err)
+	}
+}
+
+// RequestKeyframes asks the publisher for a keyframe of every video track, it is used when new subscribers attach
+func (c *Connector) RequestKeyframes() {
+	for _, track := range c.LocalTracks() {
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			c.RequestKeyframe(track.SSRC())
+		}
+	}
+}
+
+// requestKeyframesOnConnect asks the publishers for keyframes once the subscriber peer is able to receive them
+func requestKeyframesOnConnect(peer *webrtc.PeerConnection, publishers []*Connector) {
+	peer.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state != webrtc.PeerConnectionStateConnected {
+			return
+		}
+		for _, publisher := range publishers {
+			publisher.RequestKeyframes()
+		}
+	})
+}
+
+// isKeyframe detects the start of a keyframe in the payload of a VP8 or H264 packet,
+// writers of other codecs start without waiting for a keyframe
+func isKeyframe(codec *webrtc.RTPCodec, payload []byte) bool {
+	if codec == nil {
+		return true
+	}
+
+	switch strings.ToLower(codec.Name) {
+	case strings.ToLower(webrtc.VP8):
+		vp8 := &codecs.VP8Packet{}
+		frame, err := vp8.Unmarshal(payload)
+		if err != nil || vp8.S != 1 || vp8.PID != 0 || len(frame) == 0 {
+			return false
+		}
+		return frame[0]&0x01 == 0
+	case strings.ToLower(webrtc.H264):
+		return isH264Keyframe(payload)
+	}
+	return true
+}
+
+func isH264Keyframe(payload []byte) bool {
+	if len(payload) < 2 {
+		return false
+	}
+
+	const (
+		nalIDR  = 5
+		nalSPS  = 7
+		nalSTAP = 24
+		nalFU   = 28
+	)
+
+	switch nal := payload[0] & 0x1f; nal {
+	case nalIDR, nalSPS:
+		return true
+	case nalFU:
+		start := payload[1]&0x80 != 0
+		fragment := payload[1] & 0x1f
+		return start && (fragment == nalIDR || fragment == nalSPS)
+	case nalSTAP:
+		for offset := 1; offset+2 < len(payload); {
+			size := int(payload[offset])<<8 | int(payload[offset+1])
+			offset += 2
+			if offset >= len(payload) {
+				break
+			}
+			if unit := payload[offset] & 0x1f; unit == nalIDR || unit == nalSPS {
+				return true
+			}
+			offset += size
+		}
+	}
+	return false
+}
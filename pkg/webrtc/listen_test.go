@@ -0,0 +1,156 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// newListenPeers negotiates a listen peer of the connector with a client peer, the client offered first
+func newListenPeers(t *testing.T) (*Connector, *webrtc.PeerConnection) {
+	server, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+	if _, err = client.CreateDataChannel("data", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := &Connector{
+		clientID:     "bob",
+		signals:      make(chan Payload, 4),
+		listenTracks: make(map[string][]listenTrack),
+	}
+	if err = conn.HandleListenRemoteOffer(server, localOffer(t, client)); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SetRemoteDescription(nextListenSignal(t, conn)); err != nil {
+		t.Fatal(err)
+	}
+	return conn, client
+}
+
+func localOffer(t *testing.T, peer *webrtc.PeerConnection) webrtc.SessionDescription {
+	offer, err := peer.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(peer)
+	if err = peer.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gatherComplete
+	return *peer.LocalDescription()
+}
+
+// answerListen applies a server offer to the client and returns the answer of the client
+func answerListen(t *testing.T, client *webrtc.PeerConnection, offer webrtc.SessionDescription) webrtc.SessionDescription {
+	if err := client.SetRemoteDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := client.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	return answer
+}
+
+// nextListenSignal returns the session description of the next signal of the connector
+func nextListenSignal(t *testing.T, conn *Connector) webrtc.SessionDescription {
+	select {
+	case payload := <-conn.signals:
+		description, ok := payload.Signal.(webrtc.SessionDescription)
+		if !ok {
+			t.Fatalf("expected a session description, got %+v", payload)
+		}
+		return description
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a signal")
+	}
+	return webrtc.SessionDescription{}
+}
+
+func expectNoListenSignal(t *testing.T, conn *Connector) {
+	select {
+	case payload := <-conn.signals:
+		t.Fatalf("expected no signal, got %+v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestListenOfferWaitsForAnswer(t *testing.T) {
+	conn, client := newListenPeers(t)
+
+	if err := conn.OfferListenPeer(); err != nil {
+		t.Fatal(err)
+	}
+	offer := nextListenSignal(t, conn)
+
+	// The second change is offered once the client answered the first offer
+	if err := conn.OfferListenPeer(); err != nil {
+		t.Fatalf("expected the offer to wait, got %s", err)
+	}
+	expectNoListenSignal(t, conn)
+
+	if err := conn.HandleListenRemoteAnswer(answerListen(t, client, offer)); err != nil {
+		t.Fatal(err)
+	}
+	offer = nextListenSignal(t, conn)
+	if offer.Type != webrtc.SDPTypeOffer {
+		t.Fatalf("expected the pending offer, got %s", offer.Type)
+	}
+
+	if err := conn.HandleListenRemoteAnswer(answerListen(t, client, offer)); err != nil {
+		t.Fatal(err)
+	}
+	expectNoListenSignal(t, conn)
+}
+
+func TestListenOfferGlare(t *testing.T) {
+	conn, client := newListenPeers(t)
+
+	if err := conn.OfferListenPeer(); err != nil {
+		t.Fatal(err)
+	}
+	offer := nextListenSignal(t, conn)
+
+	// The client offers before it saw the offer of the server
+	clientOffer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.HandleListenRemoteOffer(conn.ListenPeer(), clientOffer); err != nil {
+		t.Fatalf("expected the client offer to be deferred, got %s", err)
+	}
+	expectNoListenSignal(t, conn)
+
+	// The tracks synced for the client offer are offered by the server, then the client is asked to offer again
+	if err = conn.HandleListenRemoteAnswer(answerListen(t, client, offer)); err != nil {
+		t.Fatal(err)
+	}
+	offer = nextListenSignal(t, conn)
+	if err = conn.HandleListenRemoteAnswer(answerListen(t, client, offer)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-conn.signals:
+		if !payload.Renegotiate || payload.Signal != nil {
+			t.Fatalf("expected a renegotiate request, got %+v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a renegotiate request")
+	}
+}
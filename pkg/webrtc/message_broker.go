@@ -1,58 +1,335 @@
 package webrtc
 
 import (
+	"errors"
 	"fmt"
+	"sync"
+
 	"github.com/pion/webrtc/v3"
 )
 
-type MessageBroker struct {
-	clientID string
+// ErrNoDataChannel is returned when a message is sent on a label the participant has no open channel for
+var ErrNoDataChannel = errors.New("no data channel")
 
-	dataChannel *webrtc.DataChannel
+// incomingQueueSize limits the received messages waiting to be delivered to the room. When it is full
+// the data channels of the participant are not read, so their client slows down instead of losing messages.
+const incomingQueueSize = 256
 
-	messageStream  chan webrtc.DataChannelMessage
-	peerConnection *webrtc.PeerConnection
-}
+type (
+	MessageBroker struct {
+		clientID string
+
+		channelsMux sync.RWMutex
+		// channels are the open data channels by label, a client may open e.g. "messages" and "game-state"
+		channels map[string]*webrtc.DataChannel
+		// queues hold the messages of each channel while the participant does not keep up
+		queues map[string]*sendQueue
+		// fallback delivers the messages for labels without an open channel, e.g. over the websocket.
+		// They wait in fallbacks for their own goroutine, so a slow websocket does not block the senders.
+		fallback     func(ChannelMessage) error
+		fallbacks    chan fallbackMessage
+		fallbackOnce sync.Once
+
+		messageStream chan ChannelMessage
+		// stopped is closed with the connector, its incoming messages and fallbacks are not delivered anymore
+		stopped        chan struct{}
+		stopOnce       sync.Once
+		peerConnection *webrtc.PeerConnection
+	}
+
+	// ChannelMessage is a data channel message with the label of the channel it was received on or is sent to
+	ChannelMessage struct {
+		webrtc.DataChannelMessage
+		Label string
+	}
+
+	// fallbackMessage calls done once the fallback sent the message or it was dropped
+	fallbackMessage struct {
+		ChannelMessage
+		done func(error)
+	}
+
+	// ChannelOptions are the delivery settings of a data channel, they are kept when a label is opened for other participants
+	ChannelOptions struct {
+		Ordered           bool
+		MaxPacketLifeTime *uint16
+		MaxRetransmits    *uint16
+	}
+)
 
 func NewMessageBroker(peerConnection *webrtc.PeerConnection, clientId string) *MessageBroker {
 	broker := &MessageBroker{
-		messageStream:  make(chan webrtc.DataChannelMessage),
+		channels:       make(map[string]*webrtc.DataChannel),
+		queues:         make(map[string]*sendQueue),
+		fallbacks:      make(chan fallbackMessage, DefaultSendQueueSize),
+		messageStream:  make(chan ChannelMessage, incomingQueueSize),
+		stopped:        make(chan struct{}),
 		peerConnection: peerConnection,
 		clientID:       clientId,
 	}
 
-	peerConnection.OnDataChannel(broker.onDataChannelHandler)
+	if peerConnection != nil {
+		peerConnection.OnDataChannel(broker.onDataChannelHandler)
+	}
 
 	return broker
 }
 
 func (mb *MessageBroker) onDataChannelHandler(dataChannel *webrtc.DataChannel) {
-	dataChannel.OnMessage(mb.onMessageHandler)
-	mb.dataChannel = dataChannel
+	label := dataChannel.Label()
+	dataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		mb.onMessageHandler(ChannelMessage{DataChannelMessage: msg, Label: label})
+	})
+	dataChannel.OnClose(func() {
+		mb.removeChannel(dataChannel)
+	})
+
+	queue := newSendQueue(mb.clientID, dataChannel, false)
+
+	mb.channelsMux.Lock()
+	previous, replaced := mb.channels[label]
+	previousQueue := mb.queues[label]
+	mb.channels[label] = dataChannel
+	mb.queues[label] = queue
+	mb.channelsMux.Unlock()
+
+	// A channel with the same label replaces the previous one, e.g. after the client reopened it
+	if replaced && previous != dataChannel {
+		previousQueue.close()
+		_ = previous.Close()
+	}
+}
+
+func (mb *MessageBroker) removeChannel(dataChannel *webrtc.DataChannel) {
+	mb.channelsMux.Lock()
+	queue, ok := mb.queues[dataChannel.Label()]
+	ok = ok && mb.channels[dataChannel.Label()] == dataChannel
+	if ok {
+		delete(mb.channels, dataChannel.Label())
+		delete(mb.queues, dataChannel.Label())
+	}
+	mb.channelsMux.Unlock()
+
+	if ok {
+		queue.close()
+	}
 }
 
-func (mb *MessageBroker) MessagesChannel() <-chan webrtc.DataChannelMessage {
+func (mb *MessageBroker) MessagesChannel() <-chan ChannelMessage {
 	return mb.messageStream
 }
 
-func (mb *MessageBroker) onMessageHandler(msg webrtc.DataChannelMessage) {
-	mb.messageStream <- msg
+// onMessageHandler is called by the read loop of the data channel, it waits while the room does not keep up
+func (mb *MessageBroker) onMessageHandler(msg ChannelMessage) {
+	select {
+	case mb.messageStream <- msg:
+		return
+	default:
+	}
+
+	dataChannelMetrics.Add("delayed_incoming", 1)
+	select {
+	case mb.messageStream <- msg:
+	case <-mb.stopped:
+		dataChannelMetrics.Add("dropped_incoming", 1)
+	}
+}
+
+// stopIncoming releases the read loops which wait for the room, the connector is closed
+func (mb *MessageBroker) stopIncoming() {
+	mb.stopOnce.Do(func() {
+		close(mb.stopped)
+	})
 }
 
-func (mb *MessageBroker) SendText(message string) error {
-	if mb.dataChannel == nil {
-		return fmt.Errorf("[%s] No data channel", mb.clientID)
+// SetFallback delivers the messages which can not be sent on a data channel of the participant,
+// up to DefaultSendQueueSize of them wait for it and further ones are dropped
+func (mb *MessageBroker) SetFallback(fallback func(ChannelMessage) error) {
+	mb.channelsMux.Lock()
+	mb.fallback = fallback
+	mb.channelsMux.Unlock()
+
+	mb.fallbackOnce.Do(func() {
+		go mb.sendFallbacks()
+	})
+}
+
+func (mb *MessageBroker) pushFallback(msg ChannelMessage, done func(error)) error {
+	select {
+	case <-mb.stopped:
+		err := fmt.Errorf("[%s] %w: %s", mb.clientID, ErrNoDataChannel, msg.Label)
+		if done != nil {
+			done(err)
+		}
+		return err
+	default:
 	}
 
-	return mb.dataChannel.SendText(message)
+	select {
+	case mb.fallbacks <- fallbackMessage{ChannelMessage: msg, done: done}:
+		return nil
+	default:
+		err := fmt.Errorf("[%s] %w: fallback of %s", mb.clientID, ErrSendQueueFull, msg.Label)
+		dropped(queuedMessage{DataChannelMessage: msg.DataChannelMessage, done: done}, err)
+		return err
+	}
 }
 
-func (mb *MessageBroker) Send(message []byte) (err error) {
-	if mb.dataChannel == nil {
-		return fmt.Errorf("[%s] No data channel", mb.clientID)
+// sendFallbacks hands the queued messages to the fallback until the connector is closed
+func (mb *MessageBroker) sendFallbacks() {
+	for {
+		select {
+		case msg := <-mb.fallbacks:
+			mb.channelsMux.RLock()
+			fallback := mb.fallback
+			mb.channelsMux.RUnlock()
+
+			err := fallback(msg.ChannelMessage)
+			if msg.done != nil {
+				msg.done(err)
+			}
+		case <-mb.stopped:
+			mb.dropFallbacks()
+			return
+		}
 	}
+}
+
+func (mb *MessageBroker) dropFallbacks() {
+	for {
+		select {
+		case msg := <-mb.fallbacks:
+			dropped(queuedMessage{DataChannelMessage: msg.DataChannelMessage, done: msg.done},
+				fmt.Errorf("[%s] %w: %s", mb.clientID, ErrNoDataChannel, msg.Label))
+		default:
+			return
+		}
+	}
+}
 
-	return mb.dataChannel.Send(message)
+// Labels returns the labels of the data channels of the participant
+func (mb *MessageBroker) Labels() []string {
+	mb.channelsMux.RLock()
+	defer mb.channelsMux.RUnlock()
+
+	labels := make([]string, 0, len(mb.channels))
+	for label := range mb.channels {
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// Options returns the delivery settings of a data channel, ok is false when the participant has no such channel
+func (mb *MessageBroker) Options(label string) (options ChannelOptions, ok bool) {
+	mb.channelsMux.RLock()
+	dataChannel, ok := mb.channels[label]
+	mb.channelsMux.RUnlock()
+	if !ok {
+		return options, false
+	}
+
+	return ChannelOptions{
+		Ordered:           dataChannel.Ordered(),
+		MaxPacketLifeTime: dataChannel.MaxPacketLifeTime(),
+		MaxRetransmits:    dataChannel.MaxRetransmits(),
+	}, true
+}
+
+// OpenChannel opens a data channel from the server side with the given settings,
+// messages sent on it before it is open are delivered once it opens.
+// Channels are only opened for participants who opened one themselves, the others have no SCTP association.
+func (mb *MessageBroker) OpenChannel(label string, options ChannelOptions) error {
+	mb.channelsMux.Lock()
+	defer mb.channelsMux.Unlock()
+
+	if _, ok := mb.channels[label]; ok {
+		return nil
+	}
+	if mb.peerConnection == nil || !mb.hasOpenChannel() {
+		return fmt.Errorf("[%s] %w: %s", mb.clientID, ErrNoDataChannel, label)
+	}
+
+	ordered := options.Ordered
+	dataChannel, err := mb.peerConnection.CreateDataChannel(label, &webrtc.DataChannelInit{
+		Ordered:           &ordered,
+		MaxPacketLifeTime: options.MaxPacketLifeTime,
+		MaxRetransmits:    options.MaxRetransmits,
+	})
+	if err != nil {
+		return fmt.Errorf("[%s] unable to open data channel %s: %w", mb.clientID, label, err)
+	}
+
+	queue := newSendQueue(mb.clientID, dataChannel, true)
+	dataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		mb.onMessageHandler(ChannelMessage{DataChannelMessage: msg, Label: label})
+	})
+	dataChannel.OnClose(func() {
+		mb.removeChannel(dataChannel)
+	})
+
+	mb.channels[label] = dataChannel
+	mb.queues[label] = queue
+	return nil
+}
+
+// hasOpenChannel has to be called with the lock held
+func (mb *MessageBroker) hasOpenChannel() bool {
+	for _, dataChannel := range mb.channels {
+		if dataChannel.ReadyState() == webrtc.DataChannelStateOpen {
+			return true
+		}
+	}
+	return false
+}
+
+// SendMessage queues the message on the channel with its label, the fallback is used when the channel is not open
+func (mb *MessageBroker) SendMessage(msg ChannelMessage) error {
+	return mb.sendMessage(msg, nil)
+}
+
+// sendMessage calls done once, when the message was handed to the data channel or sent by the fallback, or was dropped
+func (mb *MessageBroker) sendMessage(msg ChannelMessage, done func(error)) error {
+	mb.channelsMux.RLock()
+	queue, ok := mb.queues[msg.Label]
+	fallback := mb.fallback
+	mb.channelsMux.RUnlock()
+
+	if ok && queue.accepts() {
+		return queue.push(queuedMessage{DataChannelMessage: msg.DataChannelMessage, done: done})
+	}
+
+	if fallback != nil {
+		return mb.pushFallback(msg, done)
+	}
+
+	err := fmt.Errorf("[%s] %w: %s", mb.clientID, ErrNoDataChannel, msg.Label)
+	if done != nil {
+		done(err)
+	}
+	return err
+}
+
+// SendText sends a text message on the channel with the given label
+func (mb *MessageBroker) SendText(label, message string) error {
+	return mb.SendMessage(ChannelMessage{
+		DataChannelMessage: webrtc.DataChannelMessage{IsString: true, Data: []byte(message)},
+		Label:              label,
+	})
+}
+
+// Send sends a binary message on the channel with the given label
+func (mb *MessageBroker) Send(label string, message []byte) (err error) {
+	return mb.SendMessage(ChannelMessage{
+		DataChannelMessage: webrtc.DataChannelMessage{Data: message},
+		Label:              label,
+	})
+}
+
+func send(dataChannel *webrtc.DataChannel, msg webrtc.DataChannelMessage) error {
+	if msg.IsString {
+		return dataChannel.SendText(string(msg.Data))
+	}
+	return dataChannel.Send(msg.Data)
 }
 
 //func (d *DataTransceiver) Close() {
@@ -0,0 +1,219 @@
+package webrtc
+
+import (
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// packetBufferSize is the number of recent packets kept per video track, it has to be a power of two
+	packetBufferSize = 512
+
+	// nackReorderDelay is how long a gap may be filled by reordered packets before it is requested
+	nackReorderDelay = 15 * time.Millisecond
+	// nackInterval is the time between two NACKs for the same missing packet
+	nackInterval = 40 * time.Millisecond
+	// nackMaxAttempts is how often a missing packet is requested before it is given up
+	nackMaxAttempts = 3
+	// nackMaxGap is the largest gap which is requested, a larger jump is treated as a stream reset
+	nackMaxGap = 128
+)
+
+type (
+	// packetBuffer keeps the last packets of a track to answer the NACKs of subscribers
+	packetBuffer struct {
+		mux     sync.RWMutex
+		packets [packetBufferSize][]byte
+		seqs    [packetBufferSize]uint16
+		stored  [packetBufferSize]bool
+	}
+
+	// nackGenerator detects sequence number gaps of a publisher track
+	nackGenerator struct {
+		started bool
+		highest uint16
+		missing map[uint16]*missingPacket
+	}
+
+	missingPacket struct {
+		detectedAt time.Time
+		attempts   int
+		sentAt     time.Time
+	}
+)
+
+func newPacketBuffer() *packetBuffer {
+	return &packetBuffer{}
+}
+
+// push copies the raw packet, the forwarding loop reuses its read buffer
+func (b *packetBuffer) push(seq uint16, raw []byte) {
+	index := seq % packetBufferSize
+
+	b.mux.Lock()
+	b.packets[index] = append(b.packets[index][:0], raw...)
+	b.seqs[index] = seq
+	b.stored[index] = true
+	b.mux.Unlock()
+}
+
+func (b *packetBuffer) get(seq uint16) (*rtp.Packet, bool) {
+	index := seq % packetBufferSize
+
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	if !b.stored[index] || b.seqs[index] != seq {
+		return nil, false
+	}
+
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(append([]byte(nil), b.packets[index]...)); err != nil {
+		return nil, false
+	}
+	return packet, true
+}
+
+func newNackGenerator() *nackGenerator {
+	return &nackGenerator{missing: make(map[uint16]*missingPacket)}
+}
+
+// push records the packet received at now, the packets it skips are missing from now on
+func (g *nackGenerator) push(seq uint16, now time.Time) {
+	if !g.started {
+		g.started = true
+		g.highest = seq
+		return
+	}
+
+	diff := seq - g.highest
+	switch {
+	case diff == 0:
+		return
+	case diff >= 0x8000:
+		// Late or retransmitted packet
+		delete(g.missing, seq)
+		return
+	case diff > nackMaxGap:
+		g.missing = make(map[uint16]*missingPacket)
+	default:
+		for missing := g.highest + 1; missing != seq; missing++ {
+			g.missing[missing] = &missingPacket{detectedAt: now}
+		}
+	}
+	g.highest = seq
+
+	for missing := range g.missing {
+		if g.highest-missing >= packetBufferSize {
+			delete(g.missing, missing)
+		}
+	}
+}
+
+// due returns the missing packets which have to be requested now, a packet is requested
+// after the reorder delay and then every nackInterval
+func (g *nackGenerator) due(now time.Time) []uint16 {
+	var seqs []uint16
+	for seq, missing := range g.missing {
+		if missing.attempts == 0 && now.Sub(missing.detectedAt) < nackReorderDelay {
+			continue
+		}
+		if missing.attempts != 0 && now.Sub(missing.sentAt) < nackInterval {
+			continue
+		}
+		if missing.attempts >= nackMaxAttempts {
+			delete(g.missing, seq)
+			continue
+		}
+		missing.attempts++
+		missing.sentAt = now
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+// nackPairs packs the sequence numbers into the pairs of a generic NACK, see RFC 4585 section 6.2.1
+func nackPairs(seqs []uint16) []rtcp.NackPair {
+	if len(seqs) == 0 {
+		return nil
+	}
+
+	// Sort relative to the first one, so the pairs survive a sequence number wrap
+	base := seqs[0]
+	for _, seq := range seqs {
+		if seq-base >= 0x8000 {
+			base = seq
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i]-base < seqs[j]-base
+	})
+
+	var pairs []rtcp.NackPair
+	for _, seq := range seqs {
+		if len(pairs) != 0 {
+			last := &pairs[len(pairs)-1]
+			if offset := seq - last.PacketID; offset > 0 && offset <= 16 {
+				last.LostPackets |= rtcp.PacketBitmap(1 << (offset - 1))
+				continue
+			}
+		}
+		pairs = append(pairs, rtcp.NackPair{PacketID: seq})
+	}
+	return pairs
+}
+
+func (c *Connector) packetBuffer(ssrc uint32) (*packetBuffer, bool) {
+	c.buffersMux.RLock()
+	defer c.buffersMux.RUnlock()
+
+	buffer, ok := c.buffers[ssrc]
+	return buffer, ok
+}
+
+func (c *Connector) addPacketBuffer(ssrc uint32) *packetBuffer {
+	c.buffersMux.Lock()
+	defer c.buffersMux.Unlock()
+
+	buffer := newPacketBuffer()
+	c.buffers[ssrc] = buffer
+	return buffer
+}
+
+// retransmit answers the NACK of one subscriber from the buffer of the track
+func (c *Connector) retransmit(sender *webrtc.RTPSender, nack *rtcp.TransportLayerNack) {
+	buffer, ok := c.packetBuffer(nack.MediaSSRC)
+	if !ok {
+		return
+	}
+
+	for _, pair := range nack.Nacks {
+		for _, seq := range pair.PacketList() {
+			packet, ok := buffer.get(seq)
+			if !ok {
+				continue
+			}
+			if _, err := sender.SendRTP(&packet.Header, packet.Payload); err != nil {
+				if err != io.ErrClosedPipe {
+					log.Printf("[%s] failed to retransmit rtp: %s", c.clientID, err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// requestRetransmission sends a NACK for the missing packets of a publisher track
+func (c *Connector) requestRetransmission(ssrc uint32, seqs []uint16) {
+	nack := &rtcp.TransportLayerNack{MediaSSRC: ssrc, Nacks: nackPairs(seqs)}
+	if err := c.broadCastPeer.WriteRTCP([]rtcp.Packet{nack}); err != nil && err != io.ErrClosedPipe {
+		log.Printf("[%s] failed to write NACK to broadcast peer: %s", c.clientID, err)
+	}
+}
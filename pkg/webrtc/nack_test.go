@@ -0,0 +1,128 @@
+package webrtc
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+func sortedSeqs(seqs []uint16) []uint16 {
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
+}
+
+// seqRange returns the sequence numbers from first to last
+func seqRange(first, last uint16) []uint16 {
+	var seqs []uint16
+	for seq := first; seq != last+1; seq++ {
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+func TestNackGenerator(t *testing.T) {
+	tests := []struct {
+		name    string
+		pushed  []uint16
+		missing []uint16
+	}{
+		{"in order", []uint16{1, 2, 3}, nil},
+		{"duplicate", []uint16{1, 1, 2}, nil},
+		{"gap", []uint16{1, 2, 5}, []uint16{3, 4}},
+		{"late packet", []uint16{1, 4, 2}, []uint16{3}},
+		{"retransmitted packets", []uint16{1, 4, 3, 2}, nil},
+		{"wrap", []uint16{65534, 1}, []uint16{0, 65535}},
+		{"stream reset", []uint16{1, 3, 3 + nackMaxGap + 1}, nil},
+		{"largest gap", []uint16{1, 1 + nackMaxGap}, seqRange(2, nackMaxGap)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			generator := newNackGenerator()
+			for _, seq := range test.pushed {
+				generator.push(seq, time.Now())
+			}
+
+			var missing []uint16
+			for seq := range generator.missing {
+				missing = append(missing, seq)
+			}
+			if missing = sortedSeqs(missing); !reflect.DeepEqual(missing, test.missing) {
+				t.Fatalf("expected missing %v, got %v", test.missing, missing)
+			}
+		})
+	}
+}
+
+func TestNackGeneratorDue(t *testing.T) {
+	detected := time.Now()
+	generator := newNackGenerator()
+	generator.push(1, detected)
+	generator.push(4, detected)
+
+	first := detected.Add(nackReorderDelay)
+	tests := []struct {
+		name string
+		at   time.Time
+		due  []uint16
+	}{
+		{"may be reordered", detected, nil},
+		{"within the reorder delay", first.Add(-time.Millisecond), nil},
+		{"first attempt", first, []uint16{2, 3}},
+		{"within the interval", first.Add(nackInterval / 2), nil},
+		{"second attempt", first.Add(nackInterval), []uint16{2, 3}},
+		{"third attempt", first.Add(2 * nackInterval), []uint16{2, 3}},
+		{"given up", first.Add(3 * nackInterval), nil},
+	}
+
+	// The cases run in order, each one sees the attempts of the previous ones
+	for _, test := range tests {
+		if due := sortedSeqs(generator.due(test.at)); !reflect.DeepEqual(due, test.due) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.due, due)
+		}
+	}
+	if len(generator.missing) != 0 {
+		t.Fatalf("expected the packets to be given up, got %v", generator.missing)
+	}
+}
+
+func TestNackGeneratorReordered(t *testing.T) {
+	detected := time.Now()
+	generator := newNackGenerator()
+	generator.push(1, detected)
+	generator.push(3, detected)
+
+	// The packet arrives within the reorder delay and is never requested
+	if due := generator.due(detected.Add(nackReorderDelay / 2)); len(due) != 0 {
+		t.Fatalf("expected no NACK within the reorder delay, got %v", due)
+	}
+	generator.push(2, detected.Add(nackReorderDelay/2))
+	if due := generator.due(detected.Add(nackReorderDelay)); len(due) != 0 {
+		t.Fatalf("expected no NACK for the reordered packet, got %v", due)
+	}
+}
+
+func TestNackPairs(t *testing.T) {
+	tests := []struct {
+		name  string
+		seqs  []uint16
+		pairs []rtcp.NackPair
+	}{
+		{"none", nil, nil},
+		{"single", []uint16{7}, []rtcp.NackPair{{PacketID: 7}}},
+		{"bitmap", []uint16{3, 1, 2, 17}, []rtcp.NackPair{{PacketID: 1, LostPackets: 1<<0 | 1<<1 | 1<<15}}},
+		{"beyond the bitmap", []uint16{1, 18}, []rtcp.NackPair{{PacketID: 1}, {PacketID: 18}}},
+		{"wrap", []uint16{1, 65535, 0}, []rtcp.NackPair{{PacketID: 65535, LostPackets: 1<<0 | 1<<1}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if pairs := nackPairs(test.seqs); !reflect.DeepEqual(pairs, test.pairs) {
+				t.Fatalf("expected %v, got %v", test.pairs, pairs)
+			}
+		})
+	}
+}
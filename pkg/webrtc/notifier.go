@@ -0,0 +1,27 @@
+package webrtc
+
+import "log"
+
+// Notifier delivers room events to the participants over their signalling channel
+type Notifier interface {
+	Notify(event string, payload interface{}) error
+}
+
+func (r *RoomController) SetNotifier(notifier Notifier) {
+	r.mux.Lock()
+	r.notifier = notifier
+	r.mux.Unlock()
+}
+
+func (r *RoomController) notify(event string, payload interface{}) {
+	r.mux.RLock()
+	notifier := r.notifier
+	r.mux.RUnlock()
+
+	if notifier == nil {
+		return
+	}
+	if err := notifier.Notify(event, payload); err != nil {
+		log.Printf("[%s] unable to notify %s: %s", r.room, event, err)
+	}
+}
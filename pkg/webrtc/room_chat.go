@@ -0,0 +1,56 @@
+package webrtc
+
+import (
+	"log"
+	"time"
+
+	"pion-conference/pkg/chat"
+
+	"github.com/google/uuid"
+)
+
+// EnvelopeTypeChat marks the envelopes which are kept in the chat history of the room, direct ones are not kept
+const EnvelopeTypeChat = "chat"
+
+// ChatStore keeps the chat history of all rooms, it outlives the rooms so the history can be exported.
+// The history of an ended room is deleted by the chat.Retention.
+var ChatStore chat.Store = chat.NewMemory(chat.DefaultMaxMessages)
+
+// ChatHistory returns the chat messages of the room, the oldest first
+func (r *RoomController) ChatHistory() ([]chat.Message, error) {
+	return ChatStore.History(r.room)
+}
+
+// EndChat marks the end of the room when its last participant left
+func (r *RoomController) EndChat() {
+	if err := ChatStore.End(r.room, time.Now()); err != nil {
+		log.Printf("[%s] unable to end chat: %s", r.room, err)
+	}
+}
+
+// ReopenChat keeps the history of an ended room when a participant joins it again
+func (r *RoomController) ReopenChat() {
+	if err := ChatStore.Reopen(r.room); err != nil {
+		log.Printf("[%s] unable to reopen chat: %s", r.room, err)
+	}
+}
+
+// recordChat stores a broadcast chat envelope, the id is assigned when the client did not set one
+func (r *RoomController) recordChat(envelope *Envelope) {
+	if envelope.Type != EnvelopeTypeChat || len(envelope.To) != 0 {
+		return
+	}
+	if envelope.ID == "" {
+		envelope.ID = uuid.New().String()
+	}
+
+	message := chat.Message{
+		ID:      envelope.ID,
+		From:    envelope.From,
+		Time:    *envelope.Time,
+		Payload: envelope.Payload,
+	}
+	if err := ChatStore.Append(r.room, message); err != nil {
+		log.Printf("[%s] unable to store chat message of %s: %s", r.room, envelope.From, err)
+	}
+}
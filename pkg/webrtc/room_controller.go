@@ -1,36 +1,129 @@
 package webrtc
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"sync"
 
+	"pion-conference/pkg/egress"
+	"pion-conference/pkg/recording"
+	"pion-conference/pkg/speaker"
+
 	"github.com/pion/webrtc/v3"
 )
 
+// ErrClientExists is returned when a participant joins with the id of a participant in the room
+var ErrClientExists = errors.New("client is already in the room")
+
 type RoomController struct {
 	mux sync.RWMutex
 
+	room       string
 	connectors map[string]*Connector
+	recording  *recording.Session
+	speakers   *speaker.Detector
+	notifier   Notifier
+	codecs     CodecPolicy
+	lastN      int
+	// recent orders the participants by their last speech, it decides the Last-N
+	recent []string
+
+	maxScreenShares int
+	screenSharers   []string
+
+	egressMux sync.RWMutex
+	egresses  map[string]*egress.Session
+
+	viewersMux sync.RWMutex
+	viewers    map[string]*Viewer
+
+	stateMux sync.RWMutex
+	// state is shared by the participants and kept for the lifetime of the room
+	state        map[string]StateEntry
+	stateVersion uint64
 }
 
-func NewRoomController() *RoomController {
+func NewRoomController(room string) *RoomController {
 	return &RoomController{
+		room:       room,
 		connectors: make(map[string]*Connector),
+		codecs:     DefaultCodecPolicy,
+		lastN:      DefaultLastN,
+		egresses:   make(map[string]*egress.Session),
+		viewers:    make(map[string]*Viewer),
+		state:      make(map[string]StateEntry),
+
+		maxScreenShares: DefaultMaxScreenShares,
 	}
 }
 
-func (r *RoomController) Add(connector *Connector) {
+func (r *RoomController) Room() string {
+	return r.room
+}
+
+// CodecPolicy is applied to the peers created for the room
+func (r *RoomController) CodecPolicy() CodecPolicy {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.codecs
+}
+
+// SetCodecPolicy changes the codecs of the room, connected peers keep their codecs until they reconnect
+func (r *RoomController) SetCodecPolicy(policy CodecPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
 	r.mux.Lock()
-	r.connectors[connector.ClientID()] = connector
+	r.codecs = policy
 	r.mux.Unlock()
+	return nil
+}
+
+// Add joins the participant to the room, the id of a participant in the room is not taken over
+func (r *RoomController) Add(connector *Connector) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if _, ok := r.connectors[connector.ClientID()]; ok {
+		return fmt.Errorf("%w: %s", ErrClientExists, connector.ClientID())
+	}
+
+	r.connectors[connector.ClientID()] = connector
+	if r.recording != nil {
+		connector.AddSink(recordingSinkName, r.recording.Participant(connector.ClientID()))
+	}
+	r.addSpeakerSink(connector)
+	r.recent = append(r.recent, connector.ClientID())
+	r.applyLastN()
+	return nil
 }
 
 func (r *RoomController) Delete(connector *Connector) {
 	r.mux.Lock()
+	// A connector which was never added must not remove the participant with its id
+	if r.connectors[connector.ClientID()] != connector {
+		r.mux.Unlock()
+		return
+	}
 	r.RemoveClosedTracks(connector)
 	delete(r.connectors, connector.ClientID())
+	for _, publisher := range r.connectors {
+		publisher.unsubscribeTracks(connector.ClientID())
+	}
+	r.removeRecent(connector.ClientID())
+	r.applyLastN()
+	r.stopSpeakerDetector()
+	stopped := r.releaseScreenShare(connector.ClientID())
 	r.mux.Unlock()
+
+	r.releaseViewers(connector)
+	r.cancelFilesOf(connector)
+
+	if stopped {
+		r.notify(EventScreenShareStop, ScreenShareEvent{ClientID: connector.ClientID()})
+	}
 }
 
 func (r *RoomController) ProcessSignal(payload map[string]interface{}) error {
@@ -49,15 +142,20 @@ func (r *RoomController) ProcessSignal(payload map[string]interface{}) error {
 }
 
 func (r *RoomController) handleRemoteSDP(payload *Payload, sessionDescription webrtc.SessionDescription) error {
-	if sessionDescription.Type != webrtc.SDPTypeOffer {
-		return fmt.Errorf("unsupported webrtc.SDPType %s", sessionDescription.Type)
-	}
-
 	connector, ok := r.connectors[payload.ClientId]
 	if !ok {
 		return fmt.Errorf("unable to find webrtc.Connector by userId %s", payload.ClientId)
 	}
 
+	// Subscription changes are offered by the server, the client answers them
+	if sessionDescription.Type == webrtc.SDPTypeAnswer && payload.Renegotiate {
+		return connector.HandleListenRemoteAnswer(sessionDescription)
+	}
+
+	if sessionDescription.Type != webrtc.SDPTypeOffer {
+		return fmt.Errorf("unsupported webrtc.SDPType %s", sessionDescription.Type)
+	}
+
 	if !payload.Renegotiate {
 		return connector.HandleBroadcastRemoteOffer(sessionDescription)
 	}
@@ -65,38 +163,135 @@ func (r *RoomController) handleRemoteSDP(payload *Payload, sessionDescription we
 	return r.renegotiateListenPeer(connector, sessionDescription)
 }
 
+// renegotiateListenPeer answers a listen offer of the client, the current listen peer is reused
+// when the client renegotiates its peer connection, otherwise it is replaced by a new one
 func (r *RoomController) renegotiateListenPeer(conn *Connector, sessionDescription webrtc.SessionDescription) error {
 	r.mux.Lock()
 	defer r.mux.Unlock()
 
-	peerConnection, err := webrtc.NewPeerConnection(PeerConfig)
+	if conn.reusesListenPeer(sessionDescription) {
+		if _, _, err := r.syncListenTracks(conn, conn.ListenPeer()); err != nil {
+			return err
+		}
+		r.applyLastN()
+		return conn.HandleListenRemoteOffer(conn.ListenPeer(), sessionDescription)
+	}
+
+	peerConnection, err := newListenAPI(r.codecs).NewPeerConnection(PeerConfig)
 	if err != nil {
 		return fmt.Errorf("unable to create new webrtc.PeerConnection:%s", err.Error())
 	}
+	// Writers of tracks the new peer does not send again would keep writing to the closed peer
+	defer r.releaseListenTracks(conn, conn.replaceListenPeer(peerConnection))
 
-	var tracks []*webrtc.Track
+	publishers, _, err := r.syncListenTracks(conn, peerConnection)
+	if err != nil {
+		return err
+	}
+	requestKeyframesOnConnect(peerConnection, publishers)
+	r.applyLastN()
+
+	return conn.HandleListenRemoteOffer(peerConnection, sessionDescription)
+}
+
+// releaseListenTracks stops the writers of the previous listen peer for the tracks the current one does not send
+func (r *RoomController) releaseListenTracks(conn *Connector, previous map[string][]listenTrack) {
+	for clientID, tracks := range previous {
+		publisher, ok := r.connectors[clientID]
+		if !ok {
+			continue
+		}
+		for _, sent := range tracks {
+			if conn.listenSender(clientID, sent.source) != nil {
+				continue
+			}
+			if forwarder := publisher.forwarderOf(sent.source); forwarder != nil {
+				forwarder.unsubscribe(conn.ClientID())
+			}
+		}
+	}
+}
+
+// ApplySubscription brings the listen peer of the connector in line with its subscription through a server offer,
+// before the first renegotiation there is nothing to change
+func (r *RoomController) ApplySubscription(conn *Connector) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	peer := conn.ListenPeer()
+	if peer == nil || peer.RemoteDescription() == nil {
+		return nil
+	}
+
+	publishers, changed, err := r.syncListenTracks(conn, peer)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	r.applyLastN()
+
+	if err := conn.OfferListenPeer(); err != nil {
+		return err
+	}
+	for _, publisher := range publishers {
+		publisher.RequestKeyframes()
+	}
+	return nil
+}
+
+// syncListenTracks adds the subscribed tracks which the listen peer does not send yet and removes the unsubscribed ones,
+// it returns the publishers of the added tracks. It has to be called with the lock held.
+func (r *RoomController) syncListenTracks(conn *Connector, peerConnection *webrtc.PeerConnection) (publishers []*Connector, changed bool, err error) {
 	for clientID, connector := range r.connectors {
 		if clientID == conn.ClientID() {
 			continue
 		}
 
-		tracks = connector.LocalTracks()
-		for trackId := range connector.LocalTracks() {
+		added := false
+		for _, source := range connector.LocalTracks() {
+			subscribed := conn.Subscribes(clientID, source) && r.allowsTrack(source)
+			sender := conn.listenSender(clientID, source)
+
+			switch {
+			case subscribed && sender == nil:
+				track, bind, err := connector.subscriberTrack(source, conn.ClientID())
+				if err != nil {
+					return nil, false, err
+				}
+
+				sender, err := peerConnection.AddTrack(track)
+				if err != nil {
+					return nil, false, fmt.Errorf("unable to add listen track to PeerConnection:%s", err.Error())
+				}
+				bind(sender)
 
-			sender, err := peerConnection.AddTrack(tracks[trackId])
-			if err != nil {
-				return fmt.Errorf("unable to add listen track to PeerConnection:%s", err.Error())
+				conn.AddListenTracks(clientID, source, sender)
+				go handleSubscriberRTCP(sender, connector)
+				added = true
+			case !subscribed && sender != nil:
+				if err := conn.removeListenTrack(clientID, source); err != nil {
+					return nil, false, err
+				}
+				if forwarder := connector.forwarderOf(source); forwarder != nil {
+					forwarder.unsubscribe(conn.ClientID())
+				}
+				changed = true
 			}
+		}
 
-			conn.AddListenTracks(connector.ClientID(), sender)
+		if added {
+			publishers = append(publishers, connector)
+			changed = true
 		}
 	}
-
-	return conn.HandleListenRemoteOffer(peerConnection, sessionDescription)
+	return publishers, changed, nil
 }
 
 func (r *RoomController) RenegotiateAll(conn *Connector) {
 	r.mux.Lock()
+	event, payload := r.updateScreenShare(conn)
 	for clientID, connector := range r.connectors {
 		if clientID == conn.ClientID() {
 			continue
@@ -105,23 +300,16 @@ func (r *RoomController) RenegotiateAll(conn *Connector) {
 	}
 
 	r.mux.Unlock()
-}
-
-func (r *RoomController) BroadCastMessage(message webrtc.DataChannelMessage, conn *Connector) {
-	for clientID, connector := range r.connectors {
-		if clientID == conn.ClientID() {
-			continue
-		}
 
-		if err := r.sendMessage(message, connector); err != nil {
-			log.Printf("[%s] broadcast error: %s", clientID, err)
-		}
+	r.attachViewers(conn)
+	if event != "" {
+		r.notify(event, payload)
 	}
 }
 
 func (r *RoomController) RemoveClosedTracks(conn *Connector) {
 	for clientID, connector := range r.connectors {
-		if clientID == conn.ClientID() {
+		if clientID == conn.ClientID() || connector.PublishOnly() {
 			continue
 		}
 
@@ -132,10 +320,10 @@ func (r *RoomController) RemoveClosedTracks(conn *Connector) {
 	}
 }
 
-func (r *RoomController) sendMessage(message webrtc.DataChannelMessage, connector *Connector) error {
-	if message.IsString {
-		return connector.SendText(string(message.Data))
+func (r *RoomController) allowsTrack(track *webrtc.Track) bool {
+	if codec := track.Codec(); codec != nil && !r.codecs.Allows(codec.Name) {
+		log.Printf("[%s] skip %s track %s, codec is not allowed in the room", r.room, codec.Name, track.Label())
+		return false
 	}
-
-	return connector.Send(message.Data)
+	return r.allowsScreenShare(track)
 }
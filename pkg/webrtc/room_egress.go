@@ -0,0 +1,68 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+
+	"pion-conference/pkg/egress"
+)
+
+var ErrEgressNotFound = errors.New("egress session not found")
+
+func (r *RoomController) StartEgress(clientID string, host string, port int) (*egress.Session, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	connector, ok := r.connectors[clientID]
+	if !ok {
+		return nil, fmt.Errorf("unable to find webrtc.Connector by userId %s", clientID)
+	}
+
+	var session *egress.Session
+	session, err := egress.NewSession(clientID, host, port, connector.LocalTracks(), func() {
+		// Connector closes its sinks when it goes away, so the session is forgotten together with it
+		r.egressMux.Lock()
+		delete(r.egresses, session.ID())
+		r.egressMux.Unlock()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to start egress: %w", err)
+	}
+
+	r.egressMux.Lock()
+	r.egresses[session.ID()] = session
+	r.egressMux.Unlock()
+
+	connector.AddSink(egressSinkName(session.ID()), session)
+	connector.RequestKeyframes()
+
+	return session, nil
+}
+
+func (r *RoomController) StopEgress(id string) error {
+	session, ok := r.Egress(id)
+	if !ok {
+		return ErrEgressNotFound
+	}
+
+	r.mux.RLock()
+	connector, ok := r.connectors[session.ClientID()]
+	r.mux.RUnlock()
+
+	if ok {
+		return connector.RemoveSink(egressSinkName(id))
+	}
+	return session.Close()
+}
+
+func (r *RoomController) Egress(id string) (*egress.Session, bool) {
+	r.egressMux.RLock()
+	defer r.egressMux.RUnlock()
+
+	session, ok := r.egresses[id]
+	return session, ok
+}
+
+func egressSinkName(id string) string {
+	return "egress-" + id
+}
@@ -0,0 +1,291 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"pion-conference/pkg/transfer"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// FileChannelLabel is the data channel dedicated to file transfers. Control messages are JSON text,
+// chunks are binary frames of transfer.MarshalChunk which the server acknowledges one by one
+// once they are sent to the recipients, so senders which wait for acks keep pace with the slowest recipient.
+// A chunk which a recipient could not take is rejected instead, the sender sends it again for that recipient.
+const FileChannelLabel = "file-transfer"
+
+// Files keeps the running transfers and the stored files of all rooms
+var Files = transfer.NewManager("files", transfer.DefaultLimits())
+
+// fileMessage is a control message of the file channel:
+// the sender sends "offer" and "cancel" and receives "accept", "reject", "ack" and "complete",
+// a "reject" with the error of a full send queue asks for the chunk again,
+// the recipients receive "offer", "complete" and "cancel" and send "resume" to get the chunks again from Next
+type fileMessage struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id,omitempty"`
+	From  string          `json:"from,omitempty"`
+	Offer *transfer.Offer `json:"offer,omitempty"`
+	Next  int             `json:"next"`
+	Chunk int             `json:"chunk,omitempty"`
+	URL   string          `json:"url,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+func (r *RoomController) handleFileMessage(message ChannelMessage, conn *Connector) {
+	if !message.IsString {
+		r.handleFileChunk(message, conn)
+		return
+	}
+
+	var control fileMessage
+	if err := json.Unmarshal(message.Data, &control); err != nil {
+		r.sendFileMessage(conn, fileMessage{Type: "reject", Error: fmt.Sprintf("invalid file message: %s", err)})
+		return
+	}
+
+	switch control.Type {
+	case "offer":
+		r.handleFileOffer(control, conn)
+	case "resume":
+		r.handleFileResume(control, conn)
+	case "cancel":
+		r.handleFileCancel(control, conn)
+	default:
+		r.sendFileMessage(conn, fileMessage{Type: "reject", ID: control.ID, Error: fmt.Sprintf("unknown file message: %s", control.Type)})
+	}
+}
+
+// handleFileOffer starts a transfer, an offer with the id of an unfinished transfer resumes it
+func (r *RoomController) handleFileOffer(control fileMessage, conn *Connector) {
+	if control.Offer == nil {
+		r.sendFileMessage(conn, fileMessage{Type: "reject", ID: control.ID, Error: transfer.ErrInvalidOffer.Error()})
+		return
+	}
+	offer := *control.Offer
+
+	recipients, err := r.fileRecipients(conn, offer.To)
+	if err != nil {
+		r.sendFileMessage(conn, fileMessage{Type: "reject", ID: offer.ID, Error: err.Error()})
+		return
+	}
+
+	t, err := Files.Start(r.room, conn.ClientID(), offer, recipients)
+	if err != nil {
+		r.sendFileMessage(conn, fileMessage{Type: "reject", ID: offer.ID, Error: err.Error()})
+		return
+	}
+	t.OnExpire(func() {
+		r.cancelledFile(conn, t, transfer.ErrTransferIdle)
+	})
+
+	if t.Next() == 0 {
+		r.sendFileRecipients(conn, t, t.Recipients, fileMessage{Type: "offer", ID: t.ID, From: t.From, Offer: &t.Offer}.channelMessage(), nil)
+	}
+	r.sendFileMessage(conn, fileMessage{Type: "accept", ID: t.ID, Next: t.Resume()})
+}
+
+func (r *RoomController) handleFileChunk(message ChannelMessage, conn *Connector) {
+	id, index, data, err := transfer.UnmarshalChunk(message.Data)
+	if err != nil {
+		r.sendFileMessage(conn, fileMessage{Type: "reject", Error: err.Error()})
+		return
+	}
+
+	t, ok := Files.Transfer(r.room, id)
+	if !ok || t.From != conn.ClientID() {
+		r.sendFileMessage(conn, fileMessage{Type: "reject", ID: id, Error: transfer.ErrTransferUnknown.Error()})
+		return
+	}
+
+	if _, err = t.WriteChunk(index, data); err != nil {
+		r.sendFileMessage(conn, fileMessage{Type: "reject", ID: id, Chunk: index, Error: err.Error()})
+		return
+	}
+
+	// A chunk sent again only goes to the recipients which refused it
+	recipients, retry := t.Retry(index)
+	if !retry {
+		recipients = t.Recipients
+	}
+
+	r.sendFileRecipients(conn, t, recipients, message, func(refused []string) {
+		if len(refused) != 0 {
+			t.Refuse(index, refused)
+			r.sendFileMessage(conn, fileMessage{Type: "reject", ID: id, Chunk: index, Error: ErrSendQueueFull.Error()})
+			return
+		}
+
+		r.sendFileMessage(conn, fileMessage{Type: "ack", ID: id, Chunk: index})
+		if t.Relayed(index) {
+			r.finishFile(conn, t)
+		}
+	})
+}
+
+// finishFile completes a transfer once every recipient got every chunk
+func (r *RoomController) finishFile(conn *Connector, t *transfer.Transfer) {
+	if err := Files.Finish(t); err != nil {
+		log.Printf("[%s] unable to finish file %s of %s: %s", r.room, t.ID, conn.ClientID(), err)
+	}
+
+	done := fileMessage{Type: "complete", ID: t.ID, From: t.From}
+	if t.Store {
+		done.URL = fmt.Sprintf("/rooms/%s/files/%s", url.PathEscape(r.room), url.PathEscape(t.ID))
+	}
+	r.sendFileMessage(conn, done)
+	r.sendFileRecipients(conn, t, t.Recipients, done.channelMessage(), nil)
+}
+
+// handleFileResume sends the received chunks again to a recipient, starting from Next
+func (r *RoomController) handleFileResume(control fileMessage, conn *Connector) {
+	t, ok := Files.Transfer(r.room, control.ID)
+	if !ok || !contains(t.Recipients, conn.ClientID()) {
+		r.sendFileMessage(conn, fileMessage{Type: "reject", ID: control.ID, Error: transfer.ErrTransferUnknown.Error()})
+		return
+	}
+
+	r.resumeFile(conn, t, control.Next, t.Next())
+}
+
+// resumeFile sends the chunk once the previous one was sent, so a resume does not overflow the send queue
+func (r *RoomController) resumeFile(conn *Connector, t *transfer.Transfer, index, available int) {
+	if index >= available {
+		return
+	}
+
+	data, err := t.ReadChunk(index)
+	if err != nil {
+		r.sendFileMessage(conn, fileMessage{Type: "reject", ID: t.ID, Chunk: index, Error: err.Error()})
+		return
+	}
+
+	chunk := ChannelMessage{DataChannelMessage: webrtc.DataChannelMessage{Data: transfer.MarshalChunk(t.ID, index, data)}, Label: FileChannelLabel}
+	_ = conn.sendMessage(chunk, func(err error) {
+		if err != nil {
+			log.Printf("[%s] unable to resume file %s for %s: %s", r.room, t.ID, conn.ClientID(), err)
+			return
+		}
+		r.resumeFile(conn, t, index+1, available)
+	})
+}
+
+func (r *RoomController) handleFileCancel(control fileMessage, conn *Connector) {
+	t, ok := Files.Transfer(r.room, control.ID)
+	if !ok || t.From != conn.ClientID() {
+		r.sendFileMessage(conn, fileMessage{Type: "reject", ID: control.ID, Error: transfer.ErrTransferUnknown.Error()})
+		return
+	}
+
+	if err := Files.Cancel(t); err != nil {
+		log.Printf("[%s] unable to cancel file %s of %s: %s", r.room, t.ID, conn.ClientID(), err)
+	}
+	r.cancelledFile(conn, t, nil)
+}
+
+// cancelledFile tells the recipients that an unfinished transfer is gone, the sender is told the reason if there is one
+func (r *RoomController) cancelledFile(conn *Connector, t *transfer.Transfer, reason error) {
+	r.sendFileRecipients(conn, t, t.Recipients, fileMessage{Type: "cancel", ID: t.ID, From: t.From}.channelMessage(), nil)
+	if reason != nil {
+		r.sendFileMessage(conn, fileMessage{Type: "reject", ID: t.ID, Error: reason.Error()})
+	}
+}
+
+// cancelFilesOf drops the unfinished transfers of a participant who left, they can not be resumed anymore
+func (r *RoomController) cancelFilesOf(conn *Connector) {
+	for _, t := range Files.CancelFrom(r.room, conn.ClientID()) {
+		r.cancelledFile(conn, t, nil)
+	}
+}
+
+// fileRecipients checks that the recipients are in the room, without recipients the file goes to everybody else
+func (r *RoomController) fileRecipients(conn *Connector, to []string) ([]string, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	if len(to) == 0 {
+		recipients := make([]string, 0, len(r.connectors))
+		for clientID := range r.connectors {
+			if clientID != conn.ClientID() {
+				recipients = append(recipients, clientID)
+			}
+		}
+		return recipients, nil
+	}
+
+	for _, recipient := range to {
+		if _, ok := r.connectors[recipient]; !ok {
+			return nil, fmt.Errorf("%s: %w", recipient, ErrRecipientNotFound)
+		}
+	}
+	return to, nil
+}
+
+// sendFileRecipients relays a chunk or a control message to the recipients who are still in the room,
+// done is called once it was sent to all of them or could not be sent, with the recipients whose send queue was full
+func (r *RoomController) sendFileRecipients(conn *Connector, t *transfer.Transfer, recipients []string, message ChannelMessage, done func(refused []string)) {
+	r.mux.RLock()
+	connectors := make([]*Connector, 0, len(recipients))
+	for _, recipient := range recipients {
+		if connector, ok := r.connectors[recipient]; ok {
+			connectors = append(connectors, connector)
+		}
+	}
+	r.mux.RUnlock()
+
+	var (
+		refusedMux sync.Mutex
+		refused    []string
+	)
+	// pending counts the recipients and the loop, done is not called before every message is queued
+	pending := int32(len(connectors) + 1)
+	finish := func() {
+		if atomic.AddInt32(&pending, -1) == 0 && done != nil {
+			done(refused)
+		}
+	}
+
+	options, open := conn.Options(FileChannelLabel)
+	for _, connector := range connectors {
+		recipient := connector.ClientID()
+		_ = sendOnChannel(connector, message, options, open, func(err error) {
+			switch {
+			case errors.Is(err, ErrSendQueueFull):
+				refusedMux.Lock()
+				refused = append(refused, recipient)
+				refusedMux.Unlock()
+			case err != nil:
+				log.Printf("[%s] unable to relay file %s to %s: %s", r.room, t.ID, recipient, err)
+			}
+			finish()
+		})
+	}
+	finish()
+}
+
+func (r *RoomController) sendFileMessage(conn *Connector, message fileMessage) {
+	if err := conn.SendMessage(message.channelMessage()); err != nil {
+		log.Printf("[%s] unable to send file message to %s: %s", r.room, conn.ClientID(), err)
+	}
+}
+
+func (m fileMessage) channelMessage() ChannelMessage {
+	// fileMessage has no values json can not marshal
+	data, _ := json.Marshal(m)
+	return ChannelMessage{DataChannelMessage: webrtc.DataChannelMessage{IsString: true, Data: data}, Label: FileChannelLabel}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultLastN is the Last-N of new rooms, 0 forwards the video of every participant
+var DefaultLastN = 0
+
+// ErrInvalidLastN is returned for a negative Last-N
+var ErrInvalidLastN = errors.New("last-n must not be negative")
+
+// LastN is the number of most recent speakers whose video is forwarded to each participant, 0 when it is disabled
+func (r *RoomController) LastN() int {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.lastN
+}
+
+// SetLastN changes the Last-N of the room, the video of the other participants is paused without renegotiation
+func (r *RoomController) SetLastN(n int) error {
+	if n < 0 {
+		return ErrInvalidLastN
+	}
+
+	r.mux.Lock()
+	r.lastN = n
+	r.applyLastN()
+	r.mux.Unlock()
+	return nil
+}
+
+// Pin forwards the video of the publisher to the subscriber even when it is not one of the Last-N speakers
+func (r *RoomController) Pin(subscriberID, publisherID string, pinned bool) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	subscriber, ok := r.connectors[subscriberID]
+	if !ok {
+		return fmt.Errorf("unable to find webrtc.Connector by userId %s", subscriberID)
+	}
+	subscriber.subscription.pin(publisherID, pinned)
+	r.applyLastN()
+	return nil
+}
+
+// touchSpeakers moves the speakers to the front of the recent list, the dominant one first.
+// It has to be called with the lock held.
+func (r *RoomController) touchSpeakers(speakers []string) {
+	for i := len(speakers) - 1; i >= 0; i-- {
+		if _, ok := r.connectors[speakers[i]]; !ok {
+			continue
+		}
+		r.removeRecent(speakers[i])
+		r.recent = append([]string{speakers[i]}, r.recent...)
+	}
+}
+
+// removeRecent has to be called with the lock held
+func (r *RoomController) removeRecent(clientID string) {
+	for i, recent := range r.recent {
+		if recent == clientID {
+			r.recent = append(r.recent[:i], r.recent[i+1:]...)
+			return
+		}
+	}
+}
+
+// applyLastN pauses the video which each participant should not receive, it has to be called with the lock held.
+// Participants who never spoke keep their join order, audio and static bots are always forwarded.
+func (r *RoomController) applyLastN() {
+	for subscriberID, subscriber := range r.connectors {
+		forwarded := make(map[string]bool, r.lastN)
+		for _, clientID := range r.recent {
+			if len(forwarded) == r.lastN {
+				break
+			}
+			if clientID != subscriberID {
+				forwarded[clientID] = true
+			}
+		}
+
+		for publisherID, publisher := range r.connectors {
+			if publisherID == subscriberID {
+				continue
+			}
+			paused := r.lastN != 0 && !forwarded[publisherID] && !subscriber.Pins(publisherID)
+			publisher.pauseVideo(subscriberID, paused)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// MessageChannelLabel is the label of the data channel which carries envelopes, the messages of other labels
+	// are broadcast as they are, also when they look like an envelope
+	MessageChannelLabel = "messages"
+	// EnvelopeTypeDeliveryError is the type of the envelope the server returns when a direct message was not delivered
+	EnvelopeTypeDeliveryError = "deliveryError"
+)
+
+// ErrRecipientNotFound is returned for a recipient who is not in the room of the sender
+var ErrRecipientNotFound = errors.New("recipient is not in the room")
+
+type (
+	// Envelope wraps a text message on the MessageChannelLabel, e.g. {"type": "chat", "to": ["bob"], "payload": {...}}.
+	// Messages without recipients go to everybody else, From and Time are always set by the server.
+	Envelope struct {
+		ID      string          `json:"id,omitempty"`
+		Type    string          `json:"type"`
+		From    string          `json:"from,omitempty"`
+		To      []string        `json:"to,omitempty"`
+		Time    *time.Time      `json:"time,omitempty"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}
+
+	// DeliveryError tells the sender which recipient did not receive the envelope with the id
+	DeliveryError struct {
+		ID        string `json:"id,omitempty"`
+		Recipient string `json:"recipient"`
+		Error     string `json:"error"`
+	}
+)
+
+// parseEnvelope returns nil for messages of other labels, binary messages and text which is not an envelope,
+// they are broadcast as they are
+func parseEnvelope(message ChannelMessage) *Envelope {
+	if message.Label != MessageChannelLabel || !message.IsString {
+		return nil
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(message.Data, &envelope); err != nil || envelope.Type == "" {
+		return nil
+	}
+	return &envelope
+}
+
+// DeliverMessage routes a data channel message of the participant, envelopes with recipients are delivered
+// to them only and failed deliveries are reported back to the sender on the same channel
+func (r *RoomController) DeliverMessage(message ChannelMessage, conn *Connector) {
+	if message.Label == FileChannelLabel {
+		r.handleFileMessage(message, conn)
+		return
+	}
+
+	envelope := parseEnvelope(message)
+	if envelope == nil {
+		r.BroadCastMessage(message, conn)
+		return
+	}
+
+	now := time.Now().UTC()
+	envelope.From, envelope.Time = conn.ClientID(), &now
+	r.recordChat(envelope)
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		log.Printf("[%s] unable to marshal envelope: %s", conn.ClientID(), err)
+		return
+	}
+	message.Data = data
+
+	if len(envelope.To) == 0 {
+		r.BroadCastMessage(message, conn)
+		return
+	}
+
+	for _, recipient := range envelope.To {
+		recipient := recipient
+		r.deliver(message, conn, recipient, func(err error) {
+			if err != nil {
+				r.reportDeliveryError(message.Label, conn, DeliveryError{ID: envelope.ID, Recipient: recipient, Error: err.Error()})
+			}
+		})
+	}
+}
+
+// BroadCastMessage delivers a data channel message to everybody else on the channel with the same label,
+// participants without that channel get it opened with the settings of the sender channel.
+// Opening a channel negotiates with the peer, so the room is not locked while the message is sent.
+func (r *RoomController) BroadCastMessage(message ChannelMessage, conn *Connector) {
+	r.mux.RLock()
+	connectors := make([]*Connector, 0, len(r.connectors))
+	for clientID, connector := range r.connectors {
+		if clientID != conn.ClientID() {
+			connectors = append(connectors, connector)
+		}
+	}
+	r.mux.RUnlock()
+
+	options, ok := conn.Options(message.Label)
+	for _, connector := range connectors {
+		if err := sendOnChannel(connector, message, options, ok, nil); err != nil {
+			log.Printf("[%s] broadcast error: %s", connector.ClientID(), err)
+		}
+	}
+}
+
+// SendMessage delivers a message to one participant on the channel with the label of the message
+func (r *RoomController) SendMessage(clientID string, message ChannelMessage) error {
+	r.mux.RLock()
+	connector, ok := r.connectors[clientID]
+	r.mux.RUnlock()
+	if !ok {
+		return fmt.Errorf("unable to find webrtc.Connector by userId %s", clientID)
+	}
+
+	return connector.SendMessage(message)
+}
+
+// deliver sends a direct message, done is called once with the result like for sendMessage
+func (r *RoomController) deliver(message ChannelMessage, conn *Connector, recipient string, done func(error)) {
+	r.mux.RLock()
+	connector, ok := r.connectors[recipient]
+	r.mux.RUnlock()
+	if !ok {
+		done(ErrRecipientNotFound)
+		return
+	}
+
+	options, ok := conn.Options(message.Label)
+	_ = sendOnChannel(connector, message, options, ok, done)
+}
+
+func (r *RoomController) reportDeliveryError(label string, conn *Connector, deliveryError DeliveryError) {
+	payload, err := json.Marshal(deliveryError)
+	if err != nil {
+		log.Printf("[%s] unable to marshal delivery error: %s", conn.ClientID(), err)
+		return
+	}
+
+	data, err := json.Marshal(Envelope{ID: deliveryError.ID, Type: EnvelopeTypeDeliveryError, Payload: payload})
+	if err != nil {
+		log.Printf("[%s] unable to marshal delivery error: %s", conn.ClientID(), err)
+		return
+	}
+
+	reply := ChannelMessage{DataChannelMessage: webrtc.DataChannelMessage{IsString: true, Data: data}, Label: label}
+	if err := conn.SendMessage(reply); err != nil {
+		log.Printf("[%s] unable to report delivery error: %s", conn.ClientID(), err)
+	}
+}
+
+// sendOnChannel opens the label for the participant with the options of the sender channel when it is known,
+// participants without data channels get the message through their fallback. done is called like for sendMessage.
+func sendOnChannel(connector *Connector, message ChannelMessage, options ChannelOptions, open bool, done func(error)) error {
+	if open {
+		if err := connector.OpenChannel(message.Label, options); err != nil && !errors.Is(err, ErrNoDataChannel) {
+			if done != nil {
+				done(err)
+			}
+			return err
+		}
+	}
+	return connector.sendMessage(message, done)
+}
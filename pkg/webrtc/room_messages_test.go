@@ -0,0 +1,36 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestParseEnvelope(t *testing.T) {
+	tests := []struct {
+		name     string
+		label    string
+		text     bool
+		data     string
+		envelope bool
+	}{
+		{"envelope", MessageChannelLabel, true, `{"type":"chat","payload":{"text":"hi"}}`, true},
+		// Application messages of other labels are relayed untouched, e.g. the state of a game
+		{"other label", "game-state", true, `{"type":"move","x":1}`, false},
+		{"binary", MessageChannelLabel, false, `{"type":"chat"}`, false},
+		{"no type", MessageChannelLabel, true, `{"payload":{}}`, false},
+		{"no json", MessageChannelLabel, true, `hello`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			envelope := parseEnvelope(ChannelMessage{
+				DataChannelMessage: webrtc.DataChannelMessage{IsString: test.text, Data: []byte(test.data)},
+				Label:              test.label,
+			})
+			if (envelope != nil) != test.envelope {
+				t.Fatalf("expected envelope %v, got %+v", test.envelope, envelope)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	EventTrackMute   = "trackMute"
+	EventTrackUnmute = "trackUnmute"
+)
+
+// ErrTrackNotFound is returned when a publisher has no track with the given label or kind
+var ErrTrackNotFound = errors.New("track not found")
+
+// TrackMuteEvent tells the room that a publisher muted or unmuted one of its tracks
+type TrackMuteEvent struct {
+	ClientID string `json:"clientId"`
+	Track    string `json:"track"`
+	Kind     string `json:"kind"`
+	Muted    bool   `json:"muted"`
+}
+
+// SetTracksMuted stops or resumes forwarding the local tracks matching the selector,
+// which is either a track label like "pion-video-<clientId>" or a kind like "video"
+func (c *Connector) SetTracksMuted(selector string, muted bool) ([]*webrtc.Track, error) {
+	var tracks []*webrtc.Track
+	for _, track := range c.LocalTracks() {
+		if track.Label() != selector && codecTypes[track.Kind()] != selector {
+			continue
+		}
+
+		forwarder := c.forwarderOf(track)
+		if forwarder == nil {
+			// Static connectors write their tracks directly, there is nothing to pause
+			continue
+		}
+		forwarder.setMuted(muted)
+		tracks = append(tracks, track)
+	}
+
+	if len(tracks) == 0 {
+		return nil, ErrTrackNotFound
+	}
+	return tracks, nil
+}
+
+// MutedTracks returns the mute events of the tracks the publisher muted
+func (c *Connector) MutedTracks() []TrackMuteEvent {
+	var events []TrackMuteEvent
+	for _, track := range c.LocalTracks() {
+		if forwarder := c.forwarderOf(track); forwarder != nil && forwarder.isMuted() {
+			events = append(events, newTrackMuteEvent(c.clientID, track, true))
+		}
+	}
+	return events
+}
+
+// SetTrackMuted mutes or unmutes tracks of a participant and broadcasts the new state to the room
+func (r *RoomController) SetTrackMuted(clientID, selector string, muted bool) error {
+	r.mux.RLock()
+	connector, ok := r.connectors[clientID]
+	r.mux.RUnlock()
+	if !ok {
+		return fmt.Errorf("unable to find webrtc.Connector by userId %s", clientID)
+	}
+
+	tracks, err := connector.SetTracksMuted(selector, muted)
+	if err != nil {
+		return fmt.Errorf("unable to mute %s of %s: %w", selector, clientID, err)
+	}
+
+	// Muting the screen share stops sharing, unmuting it has to respect the limit of the room
+	shareErr := r.updateMutedScreenShare(connector, tracks)
+
+	event := EventTrackUnmute
+	if muted {
+		event = EventTrackMute
+	}
+	for _, track := range tracks {
+		if shareErr != nil && isScreenTrack(track) {
+			continue
+		}
+		r.notify(event, newTrackMuteEvent(clientID, track, muted))
+	}
+	return shareErr
+}
+
+// MutedTracks returns the muted tracks of all participants, joining clients render them as avatars
+func (r *RoomController) MutedTracks() []TrackMuteEvent {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	events := make([]TrackMuteEvent, 0)
+	for _, connector := range r.connectors {
+		events = append(events, connector.MutedTracks()...)
+	}
+	return events
+}
+
+func (r *RoomController) updateMutedScreenShare(connector *Connector, tracks []*webrtc.Track) error {
+	var screens []*webrtc.Track
+	for _, track := range tracks {
+		if isScreenTrack(track) {
+			screens = append(screens, track)
+		}
+	}
+	if len(screens) == 0 {
+		return nil
+	}
+
+	r.mux.Lock()
+	event, payload := r.updateScreenShare(connector)
+	switch event {
+	case EventScreenShareRejected:
+		for _, screen := range screens {
+			connector.forwarderOf(screen).setMuted(true)
+		}
+	case EventScreenShareStart:
+		// The screen tracks were left out of the listen peers while the participant was not admitted
+		for clientID, other := range r.connectors {
+			if clientID != connector.ClientID() {
+				other.RenegotiateRequest()
+			}
+		}
+	}
+	r.mux.Unlock()
+
+	if event == "" {
+		return nil
+	}
+	r.notify(event, payload)
+	if event == EventScreenShareRejected {
+		return ErrScreenShareLimit
+	}
+	return nil
+}
+
+func newTrackMuteEvent(clientID string, track *webrtc.Track, muted bool) TrackMuteEvent {
+	return TrackMuteEvent{
+		ClientID: clientID,
+		Track:    track.Label(),
+		Kind:     codecTypes[track.Kind()],
+		Muted:    muted,
+	}
+}
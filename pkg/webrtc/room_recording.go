@@ -0,0 +1,78 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"pion-conference/pkg/recording"
+	"pion-conference/pkg/recording/storage"
+)
+
+const (
+	recordingSinkName = "recording"
+
+	EventRecordingFailed = "recordingFailed"
+)
+
+// RecordingFailedEvent tells the room that the recording of a participant stopped, the others are still recorded
+type RecordingFailedEvent struct {
+	ClientID string `json:"clientId"`
+	Error    string `json:"error"`
+}
+
+var ErrRecordingNotStarted = errors.New("room recording is not started")
+
+func (r *RoomController) StartRecording(store storage.Storage, mode recording.Mode) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.recording != nil {
+		return fmt.Errorf("room %s is already being recorded", r.room)
+	}
+
+	session, err := recording.NewSession(store, r.room, mode)
+	if err != nil {
+		return fmt.Errorf("unable to start recording session: %w", err)
+	}
+	// Failures are reported from the forwarding loop with the sinks locked, StopRecording locks them under the room lock
+	session.OnFailure(func(clientID string, err error) {
+		log.Printf("[%s] recording of %s failed: %s", r.room, clientID, err)
+		go r.notify(EventRecordingFailed, RecordingFailedEvent{ClientID: clientID, Error: err.Error()})
+	})
+
+	for _, connector := range r.connectors {
+		connector.AddSink(recordingSinkName, session.Participant(connector.ClientID()))
+		// Video files start with a keyframe
+		connector.RequestKeyframes()
+	}
+	r.recording = session
+
+	return nil
+}
+
+func (r *RoomController) StopRecording() (*recording.Manifest, error) {
+	r.mux.Lock()
+	session := r.recording
+	r.recording = nil
+
+	if session == nil {
+		r.mux.Unlock()
+		return nil, ErrRecordingNotStarted
+	}
+
+	for clientID, connector := range r.connectors {
+		if err := connector.RemoveSink(recordingSinkName); err != nil {
+			log.Printf("[%s] unable to finalize recording: %s", clientID, err)
+		}
+	}
+	r.mux.Unlock()
+
+	return session.Close()
+}
+
+func (r *RoomController) IsRecording() bool {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.recording != nil
+}
@@ -0,0 +1,97 @@
+package webrtc
+
+import (
+	"pion-conference/pkg/speaker"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	speakerSinkName    = "speaker"
+	EventActiveSpeaker = "activeSpeaker"
+)
+
+type (
+	ActiveSpeakerEvent struct {
+		Dominant string   `json:"dominant"`
+		Speakers []string `json:"speakers"`
+	}
+
+	// speakerSink feeds the audio levels of a connector to the speaker detector of the room
+	speakerSink struct {
+		connector *Connector
+		detector  *speaker.Detector
+	}
+)
+
+func (s speakerSink) WriteRTP(remote *webrtc.Track, packet *rtp.Packet) error {
+	if remote.Kind() != webrtc.RTPCodecTypeAudio {
+		return nil
+	}
+
+	id := s.connector.AudioLevelExtensionID()
+	if id == 0 {
+		return nil
+	}
+
+	payload := packet.GetExtension(id)
+	if payload == nil {
+		return nil
+	}
+
+	// A malformed extension of one packet does not stop the detection
+	var level rtp.AudioLevelExtension
+	if err := level.Unmarshal(payload); err != nil {
+		return nil
+	}
+	s.detector.Observe(s.connector.ClientID(), level.Level)
+
+	return nil
+}
+
+func (s speakerSink) Close() error {
+	s.detector.Remove(s.connector.ClientID())
+	return nil
+}
+
+// ActiveSpeakers returns the last reported speakers, the dominant one first
+func (r *RoomController) ActiveSpeakers() []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	if r.speakers == nil {
+		return nil
+	}
+	return r.speakers.Speakers()
+}
+
+// addSpeakerSink has to be called with the room lock held
+func (r *RoomController) addSpeakerSink(connector *Connector) {
+	if r.speakers == nil {
+		r.speakers = speaker.NewDetector(r.onSpeakersChange)
+	}
+	connector.AddSink(speakerSinkName, speakerSink{connector: connector, detector: r.speakers})
+}
+
+// stopSpeakerDetector has to be called with the room lock held
+func (r *RoomController) stopSpeakerDetector() {
+	if r.speakers == nil || len(r.connectors) != 0 {
+		return
+	}
+	r.speakers.Close()
+	r.speakers = nil
+}
+
+func (r *RoomController) onSpeakersChange(speakers []string) {
+	r.mux.Lock()
+	r.touchSpeakers(speakers)
+	r.applyLastN()
+	r.mux.Unlock()
+
+	event := ActiveSpeakerEvent{Speakers: speakers}
+	if len(speakers) != 0 {
+		event.Dominant = speakers[0]
+	}
+	r.notify(EventActiveSpeaker, event)
+}
@@ -0,0 +1,133 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// EventStateChange is sent to the room for every written or deleted key, the payload is the StateEntry
+	EventStateChange = "stateChange"
+
+	// MaxStateKeys limits the keys of a room
+	MaxStateKeys = 1000
+	// MaxStateKeyLength limits the length of a key
+	MaxStateKeyLength = 256
+	// MaxStateValueSize limits the encoded size of a value
+	MaxStateValueSize = 64 << 10
+)
+
+var (
+	ErrInvalidStateKey    = errors.New("invalid state key")
+	ErrInvalidStateValue  = errors.New("invalid state value")
+	ErrStateValueTooLarge = errors.New("state value is too large")
+	ErrStateFull          = errors.New("room state is full")
+	ErrStateConflict      = errors.New("state version conflict")
+)
+
+type (
+	// StateEntry is a key of the shared room state, its version increases with every write in the room
+	StateEntry struct {
+		Key       string          `json:"key"`
+		Value     json.RawMessage `json:"value,omitempty"`
+		Version   uint64          `json:"version"`
+		UpdatedBy string          `json:"updatedBy,omitempty"`
+		Updated   time.Time       `json:"updated"`
+		Deleted   bool            `json:"deleted,omitempty"`
+	}
+
+	// StateUpdate writes or deletes a key, the last writer wins unless Version is set:
+	// the update is then only applied when the key is at that version, 0 when it must not exist yet
+	StateUpdate struct {
+		Key     string          `json:"key"`
+		Value   json.RawMessage `json:"value,omitempty"`
+		Version *uint64         `json:"version,omitempty"`
+		Delete  bool            `json:"delete,omitempty"`
+	}
+)
+
+// State returns a snapshot of the shared state of the room ordered by key
+func (r *RoomController) State() []StateEntry {
+	r.stateMux.RLock()
+	defer r.stateMux.RUnlock()
+
+	entries := make([]StateEntry, 0, len(r.state))
+	for _, entry := range r.state {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// UpdateState applies the update of a participant, clientID is empty for updates of the server.
+// On ErrStateConflict the current entry of the key is returned.
+func (r *RoomController) UpdateState(clientID string, update StateUpdate) (StateEntry, error) {
+	if update.Key == "" || len(update.Key) > MaxStateKeyLength {
+		return StateEntry{}, fmt.Errorf("%w: %q", ErrInvalidStateKey, update.Key)
+	}
+	if !update.Delete {
+		if len(update.Value) > MaxStateValueSize {
+			return StateEntry{}, fmt.Errorf("%w: %s", ErrStateValueTooLarge, update.Key)
+		}
+		if len(update.Value) == 0 || !json.Valid(update.Value) {
+			return StateEntry{}, fmt.Errorf("%w: %s", ErrInvalidStateValue, update.Key)
+		}
+	}
+
+	// Changes are sent while the lock is held so participants receive them in version order
+	r.stateMux.Lock()
+	defer r.stateMux.Unlock()
+
+	current, exists := r.state[update.Key]
+	if update.Version != nil && *update.Version != current.Version {
+		return current, fmt.Errorf("%w: %s is at version %d", ErrStateConflict, update.Key, current.Version)
+	}
+	if update.Delete && !exists {
+		return current, nil
+	}
+	if !update.Delete && !exists && len(r.state) >= MaxStateKeys {
+		return current, fmt.Errorf("%w: %d keys", ErrStateFull, MaxStateKeys)
+	}
+
+	r.stateVersion++
+	entry := StateEntry{
+		Key:       update.Key,
+		Value:     update.Value,
+		Version:   r.stateVersion,
+		UpdatedBy: clientID,
+		Updated:   time.Now().UTC(),
+		Deleted:   update.Delete,
+	}
+
+	if update.Delete {
+		delete(r.state, update.Key)
+	} else {
+		r.state[update.Key] = entry
+	}
+
+	r.notify(EventStateChange, entry)
+	return entry, nil
+}
+
+// ClearState deletes all keys of the room
+func (r *RoomController) ClearState() {
+	r.stateMux.Lock()
+	defer r.stateMux.Unlock()
+
+	keys := make([]string, 0, len(r.state))
+	for key := range r.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		r.stateVersion++
+		delete(r.state, key)
+		r.notify(EventStateChange, StateEntry{Key: key, Version: r.stateVersion, Updated: time.Now().UTC(), Deleted: true})
+	}
+}
@@ -0,0 +1,109 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recordingNotifier keeps the state changes sent to the room
+type recordingNotifier struct {
+	changes []StateEntry
+}
+
+func (n *recordingNotifier) Notify(event string, payload interface{}) error {
+	if entry, ok := payload.(StateEntry); ok && event == EventStateChange {
+		n.changes = append(n.changes, entry)
+	}
+	return nil
+}
+
+func version(v uint64) *uint64 {
+	return &v
+}
+
+func TestUpdateState(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  StateUpdate
+		version uint64
+		err     error
+	}{
+		{"create", StateUpdate{Key: "topic", Value: json.RawMessage(`"intro"`)}, 1, nil},
+		{"last writer wins", StateUpdate{Key: "topic", Value: json.RawMessage(`"demo"`)}, 2, nil},
+		{"compare and set", StateUpdate{Key: "topic", Value: json.RawMessage(`"q&a"`), Version: version(2)}, 3, nil},
+		{"stale version", StateUpdate{Key: "topic", Value: json.RawMessage(`"late"`), Version: version(2)}, 3, ErrStateConflict},
+		{"create only", StateUpdate{Key: "topic", Value: json.RawMessage(`1`), Version: version(0)}, 3, ErrStateConflict},
+		{"create new key only", StateUpdate{Key: "host", Value: json.RawMessage(`"carol"`), Version: version(0)}, 4, nil},
+		{"delete stale version", StateUpdate{Key: "host", Delete: true, Version: version(1)}, 4, ErrStateConflict},
+		{"delete", StateUpdate{Key: "host", Delete: true, Version: version(4)}, 5, nil},
+		{"delete missing key", StateUpdate{Key: "host", Delete: true}, 0, nil},
+		{"empty key", StateUpdate{Value: json.RawMessage(`1`)}, 0, ErrInvalidStateKey},
+		{"long key", StateUpdate{Key: strings.Repeat("k", MaxStateKeyLength+1), Value: json.RawMessage(`1`)}, 0, ErrInvalidStateKey},
+		{"no value", StateUpdate{Key: "topic"}, 0, ErrInvalidStateValue},
+		{"invalid json", StateUpdate{Key: "topic", Value: json.RawMessage(`{`)}, 0, ErrInvalidStateValue},
+		{"large value", StateUpdate{Key: "topic", Value: json.RawMessage(`"` + strings.Repeat("a", MaxStateValueSize) + `"`)}, 0, ErrStateValueTooLarge},
+	}
+
+	room := NewRoomController("room")
+	notifier := &recordingNotifier{}
+	room.SetNotifier(notifier)
+
+	// The cases run in order, each one starts from the state the previous ones left
+	for _, test := range tests {
+		notified := len(notifier.changes)
+
+		entry, err := room.UpdateState("carol", test.update)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Fatalf("%s: expected %v, got %v", test.name, test.err, err)
+			}
+			if entry.Version != test.version {
+				t.Fatalf("%s: expected the current entry at version %d, got %d", test.name, test.version, entry.Version)
+			}
+			if len(notifier.changes) != notified {
+				t.Fatalf("%s: failed update was sent to the room", test.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err)
+		}
+		if entry.Version != test.version {
+			t.Fatalf("%s: expected version %d, got %d", test.name, test.version, entry.Version)
+		}
+		if test.version != 0 && (entry.UpdatedBy != "carol" || len(notifier.changes) != notified+1) {
+			t.Fatalf("%s: expected a change of carol to be sent, got %v", test.name, notifier.changes[notified:])
+		}
+	}
+
+	state := room.State()
+	if len(state) != 1 || state[0].Key != "topic" || string(state[0].Value) != `"q&a"` || state[0].Version != 3 {
+		t.Fatalf("unexpected state: %v", state)
+	}
+}
+
+func TestClearState(t *testing.T) {
+	room := NewRoomController("room")
+	notifier := &recordingNotifier{}
+	room.SetNotifier(notifier)
+
+	for _, key := range []string{"b", "a"} {
+		if _, err := room.UpdateState("", StateUpdate{Key: key, Value: json.RawMessage(`true`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	room.ClearState()
+
+	if state := room.State(); len(state) != 0 {
+		t.Fatalf("expected an empty state, got %v", state)
+	}
+	// The deletions are sent in key order and continue the versions of the room
+	deletions := notifier.changes[2:]
+	if len(deletions) != 2 || deletions[0].Key != "a" || deletions[1].Key != "b" ||
+		!deletions[0].Deleted || deletions[0].Version != 3 || deletions[1].Version != 4 {
+		t.Fatalf("unexpected deletions: %v", deletions)
+	}
+}
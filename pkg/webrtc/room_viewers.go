@@ -0,0 +1,156 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pion/webrtc/v3"
+)
+
+var ErrViewerNotFound = errors.New("viewer not found")
+
+// AddViewer answers the offer of a receive-only peer with the tracks of the publisher,
+// or with the tracks of every participant when publisher is empty
+func (r *RoomController) AddViewer(publisher string, offer webrtc.SessionDescription) (*Viewer, webrtc.SessionDescription, error) {
+	if offer.Type != webrtc.SDPTypeOffer {
+		return nil, webrtc.SessionDescription{}, fmt.Errorf("unsupported webrtc.SDPType %s", offer.Type)
+	}
+
+	var viewer *Viewer
+	viewer, err := newViewer(publisher, r.CodecPolicy(), func() {
+		r.viewersMux.Lock()
+		delete(r.viewers, viewer.ID())
+		r.viewersMux.Unlock()
+	})
+	if err != nil {
+		return nil, webrtc.SessionDescription{}, err
+	}
+
+	r.mux.RLock()
+	err = r.addViewerTracks(viewer, publisher)
+	r.mux.RUnlock()
+	if err == nil {
+		err = viewer.reserveSlots(offer.SDP, r.CodecPolicy())
+	}
+	if err != nil {
+		_ = viewer.Close()
+		return nil, webrtc.SessionDescription{}, err
+	}
+
+	r.viewersMux.Lock()
+	r.viewers[viewer.ID()] = viewer
+	r.viewersMux.Unlock()
+
+	answer, err := viewer.answer(offer)
+	if err != nil {
+		_ = viewer.Close()
+		return nil, webrtc.SessionDescription{}, err
+	}
+
+	return viewer, answer, nil
+}
+
+func (r *RoomController) addViewerTracks(viewer *Viewer, publisher string) error {
+	var publishers []*Connector
+	if publisher != "" {
+		connector, ok := r.connectors[publisher]
+		if !ok {
+			return fmt.Errorf("unable to find webrtc.Connector by userId %s", publisher)
+		}
+		publishers = append(publishers, connector)
+	} else {
+		for _, connector := range r.connectors {
+			publishers = append(publishers, connector)
+		}
+	}
+
+	for _, connector := range publishers {
+		if err := viewer.addTracks(connector, r.allowsTrack); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attachViewers forwards the tracks a publisher added after the viewers joined to their reserved tracks
+func (r *RoomController) attachViewers(publisher *Connector) {
+	viewers := r.viewersOf(publisher.ClientID())
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	for _, viewer := range viewers {
+		viewer.attach(publisher, r.allowsTrack)
+	}
+}
+
+// releaseViewers frees the reserved tracks of a publisher which left, the other publishers may take them
+func (r *RoomController) releaseViewers(publisher *Connector) {
+	viewers := r.viewersOf(publisher.ClientID())
+
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	for _, viewer := range viewers {
+		viewer.release(publisher)
+		for _, connector := range r.connectors {
+			if viewer.Publisher() == "" || viewer.Publisher() == connector.ClientID() {
+				viewer.attach(connector, r.allowsTrack)
+			}
+		}
+	}
+}
+
+// viewersOf returns the viewers which watch the publisher, alone or with the whole room
+func (r *RoomController) viewersOf(publisher string) []*Viewer {
+	r.viewersMux.RLock()
+	defer r.viewersMux.RUnlock()
+
+	viewers := make([]*Viewer, 0, len(r.viewers))
+	for _, viewer := range r.viewers {
+		if viewer.Publisher() == "" || viewer.Publisher() == publisher {
+			viewers = append(viewers, viewer)
+		}
+	}
+	return viewers
+}
+
+func (r *RoomController) AddViewerICECandidate(id string, candidate webrtc.ICECandidateInit) error {
+	viewer, ok := r.Viewer(id)
+	if !ok {
+		return ErrViewerNotFound
+	}
+	return viewer.addICECandidate(candidate)
+}
+
+func (r *RoomController) RemoveViewer(id string) error {
+	viewer, ok := r.Viewer(id)
+	if !ok {
+		return ErrViewerNotFound
+	}
+	return viewer.Close()
+}
+
+func (r *RoomController) Viewer(id string) (*Viewer, bool) {
+	r.viewersMux.RLock()
+	defer r.viewersMux.RUnlock()
+
+	viewer, ok := r.viewers[id]
+	return viewer, ok
+}
+
+// ViewersCount is the number of WHEP viewers, they are not counted as participants
+func (r *RoomController) ViewersCount() int {
+	r.viewersMux.RLock()
+	defer r.viewersMux.RUnlock()
+
+	return len(r.viewers)
+}
+
+func (r *RoomController) ParticipantsCount() int {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	return len(r.connectors)
+}
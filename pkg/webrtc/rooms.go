@@ -22,7 +22,7 @@ func (rs *RoomsService) SetRoomController(roomId string) *RoomController {
 	rs.mux.Lock()
 	roomCtrl, ok := rs.controllers[roomId]
 	if !ok {
-		newRoomCrl := NewRoomController()
+		newRoomCrl := NewRoomController(roomId)
 		rs.controllers[roomId] = newRoomCrl
 		roomCtrl = newRoomCrl
 	}
@@ -30,14 +30,22 @@ func (rs *RoomsService) SetRoomController(roomId string) *RoomController {
 	return roomCtrl
 }
 
+func (rs *RoomsService) GetRoomController(roomId string) (*RoomController, bool) {
+	rs.mux.RLock()
+	roomCtrl, ok := rs.controllers[roomId]
+	rs.mux.RUnlock()
+	return roomCtrl, ok
+}
+
 func (rs *RoomsService) DeleteRoomController(roomId string) error {
 	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
 	if _, ok := rs.controllers[roomId]; !ok {
 		return fmt.Errorf("RoomController now found: %s", roomId)
 	}
 	delete(rs.controllers, roomId)
 
-	rs.mux.Unlock()
 	return nil
 }
 
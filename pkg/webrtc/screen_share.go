@@ -0,0 +1,156 @@
+package webrtc
+
+import (
+	"errors"
+	"strings"
+
+	"pion-conference/pkg/webrtc/track"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Publishers mark a screen share with a msid track id starting with "screen", e.g. "screen-1".
+// Screen shares are published as "pion-screen-<clientId>" next to the camera.
+const (
+	ScreenTrackPrefix = "screen"
+	ScreenLabelPrefix = track.ScreenLabelPrefix
+
+	EventScreenShareStart    = "screenShareStart"
+	EventScreenShareStop     = "screenShareStop"
+	EventScreenShareRejected = "screenShareRejected"
+)
+
+// DefaultMaxScreenShares is the number of participants of new rooms who may share their screen at the same time,
+// 0 does not limit them
+var DefaultMaxScreenShares = 1
+
+var (
+	// ErrInvalidMaxScreenShares is returned for a negative limit
+	ErrInvalidMaxScreenShares = errors.New("max screen shares must not be negative")
+	// ErrScreenShareLimit is returned when a participant starts sharing while the room is at its limit
+	ErrScreenShareLimit = errors.New("too many screen shares in the room")
+)
+
+// ScreenShareEvent tells the room who started or stopped sharing, rejected events are meant for ClientID only
+type ScreenShareEvent struct {
+	ClientID string `json:"clientId"`
+	Max      int    `json:"max,omitempty"`
+}
+
+func isScreenShare(trackID string) bool {
+	return strings.HasPrefix(trackID, ScreenTrackPrefix)
+}
+
+func isScreenTrack(track *webrtc.Track) bool {
+	return strings.HasPrefix(track.Label(), ScreenLabelPrefix)
+}
+
+// screenTracks returns the local screen share tracks of the publisher
+func (c *Connector) screenTracks() []*webrtc.Track {
+	var tracks []*webrtc.Track
+	for _, forwarder := range c.forwarderList() {
+		if forwarder.screen {
+			tracks = append(tracks, forwarder.template)
+		}
+	}
+	return tracks
+}
+
+// sharesScreen reports whether the publisher sends an unmuted screen share
+func (c *Connector) sharesScreen() bool {
+	for _, forwarder := range c.forwarderList() {
+		if forwarder.screen && !forwarder.isMuted() {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxScreenShares is the number of participants who may share their screen at the same time, 0 when it is unlimited
+func (r *RoomController) MaxScreenShares() int {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.maxScreenShares
+}
+
+// SetMaxScreenShares changes the limit for new screen shares, running ones are not stopped
+func (r *RoomController) SetMaxScreenShares(n int) error {
+	if n < 0 {
+		return ErrInvalidMaxScreenShares
+	}
+
+	r.mux.Lock()
+	r.maxScreenShares = n
+	r.mux.Unlock()
+	return nil
+}
+
+// ScreenSharers returns the participants who share their screen in the order they started
+func (r *RoomController) ScreenSharers() []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return append([]string(nil), r.screenSharers...)
+}
+
+// updateScreenShare admits the screen share of the publisher when the room is below its limit and frees the slot
+// when the publisher stopped sharing, it returns the event to notify. It has to be called with the lock held.
+func (r *RoomController) updateScreenShare(conn *Connector) (event string, payload ScreenShareEvent) {
+	payload.ClientID = conn.ClientID()
+	if !conn.sharesScreen() {
+		if r.releaseScreenShare(conn.ClientID()) {
+			return EventScreenShareStop, payload
+		}
+		return "", payload
+	}
+
+	if r.isScreenSharer(conn.ClientID()) {
+		return "", payload
+	}
+	if r.maxScreenShares != 0 && len(r.screenSharers) >= r.maxScreenShares {
+		payload.Max = r.maxScreenShares
+		return EventScreenShareRejected, payload
+	}
+
+	r.screenSharers = append(r.screenSharers, conn.ClientID())
+	return EventScreenShareStart, payload
+}
+
+// releaseScreenShare frees the slot of a participant who stopped sharing, it has to be called with the lock held
+func (r *RoomController) releaseScreenShare(clientID string) bool {
+	for i, sharer := range r.screenSharers {
+		if sharer == clientID {
+			r.screenSharers = append(r.screenSharers[:i], r.screenSharers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RoomController) isScreenSharer(clientID string) bool {
+	for _, sharer := range r.screenSharers {
+		if sharer == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsScreenShare rejects the screen tracks of participants who are not admitted, it has to be called with the lock held
+func (r *RoomController) allowsScreenShare(track *webrtc.Track) bool {
+	if !isScreenTrack(track) {
+		return true
+	}
+
+	for _, sharer := range r.screenSharers {
+		connector, ok := r.connectors[sharer]
+		if !ok {
+			continue
+		}
+		for _, screen := range connector.screenTracks() {
+			if screen == track {
+				return true
+			}
+		}
+	}
+	return false
+}
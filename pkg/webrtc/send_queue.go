@@ -0,0 +1,213 @@
+package webrtc
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// DropPolicy decides what happens to the messages of a participant whose send queue is full
+type DropPolicy string
+
+const (
+	// DropOldest drops the oldest queued message to make room for the new one
+	DropOldest DropPolicy = "oldest"
+	// DropNewest drops the new message and reports it to the sender
+	DropNewest DropPolicy = "newest"
+	// DropClose drops the queue and closes the channel, the client has to reopen it
+	DropClose DropPolicy = "close"
+)
+
+var (
+	// DefaultSendQueueSize is the number of messages queued per data channel of a participant
+	DefaultSendQueueSize = 256
+	// DefaultDropPolicy is applied when the send queue of a participant is full
+	DefaultDropPolicy = DropOldest
+
+	// BufferedAmountHigh is the number of buffered bytes above which messages are queued instead of sent
+	BufferedAmountHigh uint64 = 1 << 20
+	// BufferedAmountLow is the number of buffered bytes at which the queue is sent again
+	BufferedAmountLow uint64 = 256 << 10
+
+	ErrInvalidDropPolicy = errors.New("invalid drop policy")
+	ErrSendQueueFull     = errors.New("send queue is full")
+
+	// dataChannelMetrics are published on /debug/vars
+	dataChannelMetrics = expvar.NewMap("datachannels")
+)
+
+// ParseDropPolicy parses the drop policy of the DATA_CHANNEL_DROP_POLICY setting
+func ParseDropPolicy(value string) (DropPolicy, error) {
+	switch policy := DropPolicy(value); policy {
+	case DropOldest, DropNewest, DropClose:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrInvalidDropPolicy, value)
+}
+
+type (
+	// sendQueue sends the messages of a data channel while the peer keeps up and queues them
+	// while more than BufferedAmountHigh bytes are buffered, so one slow participant does not stall the room
+	sendQueue struct {
+		mux sync.Mutex
+
+		clientID    string
+		dataChannel *webrtc.DataChannel
+		messages    []queuedMessage
+		size        int
+		policy      DropPolicy
+		// opening is set for channels opened by the server until the peer acknowledged them
+		opening bool
+		// lossless queues never drop queued messages, new ones are refused while the queue is full
+		lossless bool
+	}
+
+	// queuedMessage calls done once the message was handed to the data channel or dropped
+	queuedMessage struct {
+		webrtc.DataChannelMessage
+		done func(error)
+	}
+)
+
+func newSendQueue(clientID string, dataChannel *webrtc.DataChannel, opening bool) *sendQueue {
+	queue := &sendQueue{
+		clientID:    clientID,
+		dataChannel: dataChannel,
+		size:        DefaultSendQueueSize,
+		policy:      DefaultDropPolicy,
+		opening:     opening,
+		// File chunks are acknowledged once they are sent, the sender slows down instead of losing chunks
+		lossless: dataChannel.Label() == FileChannelLabel,
+	}
+
+	// The open message of this pion version is not ordered before the data of unordered channels,
+	// messages of opening channels are sent once the peer acknowledged it and nothing is buffered anymore
+	if opening {
+		dataChannel.SetBufferedAmountLowThreshold(0)
+		dataChannel.OnOpen(func() {
+			if dataChannel.BufferedAmount() == 0 {
+				queue.drain()
+			}
+		})
+	} else {
+		dataChannel.SetBufferedAmountLowThreshold(BufferedAmountLow)
+	}
+	dataChannel.OnBufferedAmountLow(queue.drain)
+
+	return queue
+}
+
+// accepts reports whether messages can be sent or queued on the channel
+func (q *sendQueue) accepts() bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.opening || q.dataChannel.ReadyState() == webrtc.DataChannelStateOpen
+}
+
+func (q *sendQueue) push(msg queuedMessage) error {
+	q.mux.Lock()
+
+	if !q.opening && len(q.messages) == 0 && q.dataChannel.BufferedAmount() < BufferedAmountHigh {
+		err := send(q.dataChannel, msg.DataChannelMessage)
+		q.mux.Unlock()
+		msg.finish(err)
+		return err
+	}
+
+	if len(q.messages) < q.size {
+		q.messages = append(q.messages, msg)
+		q.mux.Unlock()
+		return nil
+	}
+
+	switch {
+	case q.lossless || q.policy == DropNewest:
+		q.mux.Unlock()
+		err := fmt.Errorf("[%s] %w: %s", q.clientID, ErrSendQueueFull, q.dataChannel.Label())
+		dropped(msg, err)
+		return err
+
+	case q.policy == DropClose:
+		messages := q.messages
+		q.messages = nil
+		q.mux.Unlock()
+
+		err := fmt.Errorf("[%s] %w: %s", q.clientID, ErrSendQueueFull, q.dataChannel.Label())
+		for _, queued := range append(messages, msg) {
+			dropped(queued, err)
+		}
+		log.Printf("[%s] closing data channel %s, the send queue is full", q.clientID, q.dataChannel.Label())
+		_ = q.dataChannel.Close()
+		return err
+
+	default:
+		oldest := q.messages[0]
+		q.messages = append(q.messages[1:], msg)
+		q.mux.Unlock()
+		dropped(oldest, fmt.Errorf("[%s] %w: %s", q.clientID, ErrSendQueueFull, q.dataChannel.Label()))
+		return nil
+	}
+}
+
+// drain sends the queued messages until the high watermark is reached again
+func (q *sendQueue) drain() {
+	q.mux.Lock()
+
+	if q.opening {
+		q.opening = false
+		q.dataChannel.SetBufferedAmountLowThreshold(BufferedAmountLow)
+	}
+
+	var sent, failed []queuedMessage
+	var err error
+	for len(q.messages) > 0 && q.dataChannel.BufferedAmount() < BufferedAmountHigh {
+		msg := q.messages[0]
+		q.messages[0] = queuedMessage{}
+		q.messages = q.messages[1:]
+
+		if err = send(q.dataChannel, msg.DataChannelMessage); err != nil {
+			failed = append([]queuedMessage{msg}, q.messages...)
+			q.messages = nil
+			break
+		}
+		sent = append(sent, msg)
+	}
+	q.mux.Unlock()
+
+	// The callbacks may send the next message on this queue
+	for _, msg := range sent {
+		msg.finish(nil)
+	}
+	for _, msg := range failed {
+		dropped(msg, err)
+	}
+}
+
+// close drops the messages of a channel which closed or was replaced
+func (q *sendQueue) close() {
+	q.mux.Lock()
+	messages := q.messages
+	q.messages = nil
+	q.mux.Unlock()
+
+	for _, msg := range messages {
+		dropped(msg, fmt.Errorf("[%s] %w: %s", q.clientID, ErrNoDataChannel, q.dataChannel.Label()))
+	}
+}
+
+func (m queuedMessage) finish(err error) {
+	if m.done != nil {
+		m.done(err)
+	}
+}
+
+func dropped(msg queuedMessage, err error) {
+	dataChannelMetrics.Add("dropped_messages", 1)
+	dataChannelMetrics.Add("dropped_bytes", int64(len(msg.Data)))
+	msg.finish(err)
+}
@@ -0,0 +1,151 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestParseDropPolicy(t *testing.T) {
+	tests := []struct {
+		value  string
+		policy DropPolicy
+		valid  bool
+	}{
+		{"oldest", DropOldest, true},
+		{"newest", DropNewest, true},
+		{"close", DropClose, true},
+		{"", "", false},
+		{"Oldest", "", false},
+		{"drop", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			policy, err := ParseDropPolicy(test.value)
+			if !test.valid {
+				if !errors.Is(err, ErrInvalidDropPolicy) {
+					t.Fatalf("expected ErrInvalidDropPolicy, got %v", err)
+				}
+				return
+			}
+			if err != nil || policy != test.policy {
+				t.Fatalf("expected %s, got %s, %v", test.policy, policy, err)
+			}
+		})
+	}
+}
+
+func TestSendQueueFull(t *testing.T) {
+	tests := []struct {
+		name    string
+		label   string
+		policy  DropPolicy
+		dropped []int
+		queued  []int
+		refused bool
+	}{
+		{"drop oldest", "chat", DropOldest, []int{0}, []int{1, 2}, false},
+		{"drop newest", "chat", DropNewest, []int{2}, []int{0, 1}, true},
+		{"close", "chat", DropClose, []int{0, 1, 2}, nil, true},
+		// File chunks are refused instead of dropped whatever the policy
+		{"lossless", FileChannelLabel, DropOldest, []int{2}, []int{0, 1}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer peer.Close()
+			dataChannel, err := peer.CreateDataChannel(test.label, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Channels the peer did not acknowledge yet queue every message
+			queue := newSendQueue("carol", dataChannel, true)
+			queue.size, queue.policy = 2, test.policy
+
+			var dropped []int
+			for i := 0; i < 3; i++ {
+				index := i
+				err = queue.push(queuedMessage{
+					DataChannelMessage: webrtc.DataChannelMessage{Data: []byte(fmt.Sprint(i))},
+					done: func(err error) {
+						if !errors.Is(err, ErrSendQueueFull) {
+							t.Errorf("message %d: expected ErrSendQueueFull, got %v", index, err)
+						}
+						dropped = append(dropped, index)
+					},
+				})
+			}
+
+			if refused := errors.Is(err, ErrSendQueueFull); refused != test.refused {
+				t.Fatalf("expected refused %t, got %v", test.refused, err)
+			}
+			if !reflect.DeepEqual(dropped, test.dropped) {
+				t.Fatalf("expected dropped %v, got %v", test.dropped, dropped)
+			}
+
+			var queued []int
+			for _, msg := range queue.messages {
+				var index int
+				fmt.Sscan(string(msg.Data), &index)
+				queued = append(queued, index)
+			}
+			if !reflect.DeepEqual(queued, test.queued) {
+				t.Fatalf("expected queued %v, got %v", test.queued, queued)
+			}
+		})
+	}
+}
+
+func TestFallbackQueue(t *testing.T) {
+	defaultSize := DefaultSendQueueSize
+	DefaultSendQueueSize = 2
+	defer func() { DefaultSendQueueSize = defaultSize }()
+
+	broker := NewMessageBroker(nil, "carol")
+	defer broker.stopIncoming()
+
+	// The fallback blocks like a slow websocket, the senders do not wait for it
+	gate, sent := make(chan struct{}), make(chan string, 4)
+	broker.SetFallback(func(msg ChannelMessage) error {
+		<-gate
+		sent <- string(msg.Data)
+		return nil
+	})
+
+	results := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		message := ChannelMessage{DataChannelMessage: webrtc.DataChannelMessage{IsString: true, Data: []byte(fmt.Sprint(i))}, Label: "chat"}
+		_ = broker.sendMessage(message, func(err error) { results <- err })
+		if i == 0 {
+			// Wait until the first message is taken by the fallback
+			for len(broker.fallbacks) != 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	if err := <-results; !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("expected the message beyond the queue to be dropped, got %v", err)
+	}
+
+	close(gate)
+	var received []string
+	for i := 0; i < 3; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("expected the queued messages to be sent, got %s", err)
+		}
+		received = append(received, <-sent)
+	}
+	if !reflect.DeepEqual(received, []string{"0", "1", "2"}) {
+		t.Fatalf("expected the messages in order, got %v", received)
+	}
+}
@@ -2,6 +2,9 @@ package webrtc
 
 import (
 	"fmt"
+
+	"pion-conference/pkg/webrtc/track"
+
 	"github.com/pion/webrtc/v3"
 )
 
@@ -18,6 +21,8 @@ type (
 		Renegotiate bool        `json:"renegotiate"`
 		ClientId    string      `json:"clientId"`
 		Signal      interface{} `json:"signal,omitempty"`
+		// Tracks maps the mids of a listen peer to their publishers, it is sent with every listen renegotiation
+		Tracks []track.Metadata `json:"tracks,omitempty"`
 	}
 )
 
@@ -37,6 +42,12 @@ func NewSDPPayloadWithRenegotiate(sessionDescription webrtc.SessionDescription,
 	}
 }
 
+func NewListenSDPPayload(sessionDescription webrtc.SessionDescription, clientId string, tracks []track.Metadata) Payload {
+	payload := NewSDPPayloadWithRenegotiate(sessionDescription, clientId)
+	payload.Tracks = tracks
+	return payload
+}
+
 func NewRenegotiatePayload(clientId string) Payload {
 	return Payload{
 		ClientId:    clientId,
@@ -0,0 +1,93 @@
+package webrtc
+
+import (
+	"log"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// TrackSink receives every RTP packet the Connector forwards from its remote tracks
+type TrackSink interface {
+	WriteRTP(remote *webrtc.Track, packet *rtp.Packet) error
+	Close() error
+}
+
+func (c *Connector) AddSink(name string, sink TrackSink) {
+	c.sinksMux.Lock()
+	defer c.sinksMux.Unlock()
+
+	if previous, ok := c.sinks[name]; ok {
+		if err := previous.Close(); err != nil {
+			log.Printf("[%s] unable to close replaced sink %s: %s", c.clientID, name, err)
+		}
+	}
+	c.sinks[name] = sink
+	delete(c.failedSinks, name)
+}
+
+func (c *Connector) RemoveSink(name string) error {
+	c.sinksMux.Lock()
+	sink, ok := c.sinks[name]
+	delete(c.sinks, name)
+	delete(c.failedSinks, name)
+	c.sinksMux.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return sink.Close()
+}
+
+func (c *Connector) hasSinks() bool {
+	c.sinksMux.RLock()
+	defer c.sinksMux.RUnlock()
+	return len(c.sinks) != 0
+}
+
+// writeSinks writes the packet to every sink which did not fail yet, a failed sink is logged once and
+// disabled, its owner still removes it
+func (c *Connector) writeSinks(remote *webrtc.Track, packet *rtp.Packet) {
+	var failed map[string]TrackSink
+
+	c.sinksMux.RLock()
+	for name, sink := range c.sinks {
+		if c.failedSinks[name] {
+			continue
+		}
+		if err := sink.WriteRTP(remote, packet); err != nil {
+			log.Printf("[%s] failed to write rtp to sink %s, it is disabled: %s", c.clientID, name, err)
+			if failed == nil {
+				failed = make(map[string]TrackSink)
+			}
+			failed[name] = sink
+		}
+	}
+	c.sinksMux.RUnlock()
+
+	if failed == nil {
+		return
+	}
+	c.sinksMux.Lock()
+	for name, sink := range failed {
+		// The sink may have been replaced meanwhile
+		if c.sinks[name] == sink {
+			c.failedSinks[name] = true
+		}
+	}
+	c.sinksMux.Unlock()
+}
+
+func (c *Connector) closeSinks() {
+	c.sinksMux.Lock()
+	sinks := c.sinks
+	c.sinks = make(map[string]TrackSink)
+	c.failedSinks = make(map[string]bool)
+	c.sinksMux.Unlock()
+
+	for name, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			log.Printf("[%s] unable to close sink %s: %s", c.clientID, name, err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package webrtc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+type countingSink struct {
+	writes int
+	err    error
+}
+
+func (s *countingSink) WriteRTP(*webrtc.Track, *rtp.Packet) error {
+	s.writes++
+	return s.err
+}
+
+func (s *countingSink) Close() error {
+	return nil
+}
+
+func TestWriteSinksDisablesFailedSink(t *testing.T) {
+	conn := &Connector{clientID: "alice", sinks: make(map[string]TrackSink), failedSinks: make(map[string]bool)}
+	healthy, failing := &countingSink{}, &countingSink{err: errors.New("disk full")}
+	conn.AddSink("healthy", healthy)
+	conn.AddSink("failing", failing)
+
+	for i := 0; i < 3; i++ {
+		conn.writeSinks(nil, &rtp.Packet{})
+	}
+	if healthy.writes != 3 || failing.writes != 1 {
+		t.Fatalf("expected 3 writes to the healthy sink and 1 to the failing one, got %d and %d", healthy.writes, failing.writes)
+	}
+
+	// A replaced sink starts over
+	replaced := &countingSink{}
+	conn.AddSink("failing", replaced)
+	conn.writeSinks(nil, &rtp.Packet{})
+	if replaced.writes != 1 {
+		t.Fatalf("expected the replacing sink to be written, got %d writes", replaced.writes)
+	}
+}
@@ -0,0 +1,30 @@
+package webrtc
+
+import (
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// NewStaticConnector creates a publish-only Connector without peer connections.
+// The caller writes media into the tracks and the room fans them out like the tracks of remote participants.
+// Closes() has to be drained by the caller, the same way the websocket handler does it for regular connectors.
+func NewStaticConnector(clientId string, tracks []*webrtc.Track) *Connector {
+	connector := &Connector{
+		clientID:     clientId,
+		publishOnly:  true,
+		localTracks:  tracks,
+		signals:      make(chan Payload),
+		renegotiates: make(chan struct{}),
+		closes:       make(chan struct{}),
+		listenTracks: make(map[string][]listenTrack),
+		subscription: newSubscription(),
+		sinks:        make(map[string]TrackSink),
+
+		keyframeRequests: make(map[uint32]time.Time),
+		buffers:          make(map[uint32]*packetBuffer),
+	}
+	connector.MessageBroker = NewMessageBroker(nil, clientId)
+
+	return connector
+}
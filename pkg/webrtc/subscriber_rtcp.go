@@ -0,0 +1,62 @@
+package webrtc
+
+import (
+	"log"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// handleSubscriberRTCP reads the RTCP of a subscriber sender, it forwards PLI and FIR to the publisher
+// and answers NACKs from the packet buffer of the track. The ssrc and sequence numbers of rewritten video tracks
+// are mapped back to the ones of the publisher.
+func handleSubscriberRTCP(sender *webrtc.RTPSender, publisher *Connector) {
+	readSubscriberRTCP(sender, func() *Connector {
+		return publisher
+	})
+}
+
+// readSubscriberRTCP handles the RTCP of a sender whose publisher may change, the packets are dropped while it has none
+func readSubscriberRTCP(sender *webrtc.RTPSender, publisherOf func() *Connector) {
+	buf := make([]byte, 1500)
+	for {
+		i, err := sender.Read(buf)
+		if err != nil {
+			return
+		}
+
+		publisher := publisherOf()
+		if publisher == nil {
+			continue
+		}
+
+		packets, err := rtcp.Unmarshal(buf[:i])
+		if err != nil {
+			log.Printf("[%s] failed to unmarshal subscriber rtcp: %s", publisher.ClientID(), err)
+			continue
+		}
+
+		for _, packet := range packets {
+			switch packet := packet.(type) {
+			case *rtcp.PictureLossIndication:
+				requestKeyframe(publisher, packet.MediaSSRC)
+			case *rtcp.FullIntraRequest:
+				for _, entry := range packet.FIR {
+					requestKeyframe(publisher, entry.SSRC)
+				}
+			case *rtcp.TransportLayerNack:
+				if _, forwarder := publisher.sourceSSRC(packet.MediaSSRC); forwarder != nil {
+					forwarder.retransmit(sender, packet)
+				} else {
+					publisher.retransmit(sender, packet)
+				}
+			}
+		}
+	}
+}
+
+func requestKeyframe(publisher *Connector, ssrc uint32) {
+	if source, _ := publisher.sourceSSRC(ssrc); source != 0 {
+		publisher.RequestKeyframe(source)
+	}
+}
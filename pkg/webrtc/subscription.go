@@ -0,0 +1,104 @@
+package webrtc
+
+import (
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// subscription decides which tracks of the room a participant receives. Tracks are named by their label,
+// the stream id clients see in the msid, e.g. "pion-video-<clientId>". A track rule beats a participant rule,
+// which beats the mode: auto subscriptions receive everything which was not unsubscribed,
+// manual subscriptions receive only what was subscribed.
+type subscription struct {
+	mux sync.RWMutex
+
+	auto bool
+
+	clients map[string]bool
+	tracks  map[string]bool
+	// pinned participants are forwarded regardless of the Last-N of the room
+	pinned map[string]bool
+}
+
+func newSubscription() *subscription {
+	return &subscription{
+		auto:    true,
+		clients: make(map[string]bool),
+		tracks:  make(map[string]bool),
+		pinned:  make(map[string]bool),
+	}
+}
+
+func (s *subscription) setAuto(auto bool) {
+	s.mux.Lock()
+	s.auto = auto
+	s.mux.Unlock()
+}
+
+func (s *subscription) set(clientIDs, trackIDs []string, subscribed bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, clientID := range clientIDs {
+		s.clients[clientID] = subscribed
+	}
+	for _, trackID := range trackIDs {
+		s.tracks[trackID] = subscribed
+	}
+}
+
+func (s *subscription) pin(clientID string, pinned bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if pinned {
+		s.pinned[clientID] = true
+	} else {
+		delete(s.pinned, clientID)
+	}
+}
+
+func (s *subscription) pins(clientID string) bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.pinned[clientID]
+}
+
+func (s *subscription) includes(clientID string, track *webrtc.Track) bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	if subscribed, ok := s.tracks[track.Label()]; ok {
+		return subscribed
+	}
+	if subscribed, ok := s.clients[clientID]; ok {
+		return subscribed
+	}
+	return s.auto
+}
+
+// SetAutoSubscribe switches between receiving every track of the room and receiving only subscribed tracks
+func (c *Connector) SetAutoSubscribe(auto bool) {
+	c.subscription.setAuto(auto)
+}
+
+// Subscribe adds participants and tracks to the subscription, the listen peer is changed by the next renegotiation
+func (c *Connector) Subscribe(clientIDs, trackIDs []string) {
+	c.subscription.set(clientIDs, trackIDs, true)
+}
+
+// Unsubscribe removes participants and tracks from the subscription, the listen peer is changed by the next renegotiation
+func (c *Connector) Unsubscribe(clientIDs, trackIDs []string) {
+	c.subscription.set(clientIDs, trackIDs, false)
+}
+
+// Subscribes reports whether the connector receives the track of a publisher
+func (c *Connector) Subscribes(clientID string, track *webrtc.Track) bool {
+	return c.subscription.includes(clientID, track)
+}
+
+// Pins reports whether the connector pinned the video of a publisher
+func (c *Connector) Pins(clientID string) bool {
+	return c.subscription.pins(clientID)
+}
@@ -1,6 +1,8 @@
 package track
 
 import (
+	"strings"
+
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 )
@@ -12,6 +14,16 @@ const (
 	EventTypeRemove
 )
 
+// Sources tell the clients what a track shows, screen shares are published with the ScreenLabelPrefix label
+const (
+	SourceCamera     = "camera"
+	SourceMicrophone = "microphone"
+	SourceScreen     = "screen"
+
+	// ScreenLabelPrefix starts the label of the local tracks of a screen share
+	ScreenLabelPrefix = "pion-screen-"
+)
+
 type (
 	Event struct {
 		Track      *webrtc.Track
@@ -33,6 +45,7 @@ type (
 		UserID   string `json:"userId"`
 		StreamID string `json:"streamId"`
 		Kind     string `json:"kind"`
+		Source   string `json:"source"`
 	}
 )
 
@@ -52,5 +65,16 @@ func NewTrackMetadataFromTrack(local Info, userId string) Metadata {
 		Mid:      local.Mid,
 		StreamID: local.Label,
 		UserID:   userId,
+		Source:   sourceOf(local),
+	}
+}
+
+func sourceOf(local Info) string {
+	switch {
+	case strings.HasPrefix(local.Label, ScreenLabelPrefix):
+		return SourceScreen
+	case local.Kind == webrtc.RTPCodecTypeAudio:
+		return SourceMicrophone
 	}
+	return SourceCamera
 }
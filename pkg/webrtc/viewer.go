@@ -0,0 +1,304 @@
+package webrtc
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+)
+
+// Viewer is a receive-only peer of a WHEP player, it is not a participant of the room.
+// WHEP has no way to renegotiate, so every media section of the offer which gets no track of the current publishers
+// is answered with a reserved track instead. Tracks published later are forwarded to a free reserved track
+// of their kind and codec, the player has to offer one recvonly section per track it wants to receive
+// and to start a new session for more.
+type Viewer struct {
+	mux       sync.Mutex
+	closeOnce sync.Once
+	closed    bool
+
+	id        string
+	publisher string
+	peer      *webrtc.PeerConnection
+	senders   []*webrtc.RTPSender
+	// publishers stop forwarding to the viewer on close
+	publishers []*Connector
+
+	slots []*viewerSlot
+	// attached are the local tracks of the publishers the viewer receives
+	attached map[*webrtc.Track]bool
+
+	onClose func()
+}
+
+// viewerSlot is a reserved track, it receives the local track of one publisher at a time.
+// writer is kept after the publisher left, the next writer continues its sequence numbers for the player.
+type viewerSlot struct {
+	track     *webrtc.Track
+	sender    *webrtc.RTPSender
+	publisher *Connector
+	writer    *subscriberWriter
+}
+
+func newViewer(publisher string, policy CodecPolicy, onClose func()) (*Viewer, error) {
+	peer, err := newListenAPI(policy).NewPeerConnection(PeerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create new webrtc.PeerConnection:%s", err.Error())
+	}
+
+	viewer := &Viewer{
+		id:        uuid.New().String(),
+		publisher: publisher,
+		peer:      peer,
+		attached:  make(map[*webrtc.Track]bool),
+		onClose:   onClose,
+	}
+	peer.OnICEConnectionStateChange(viewer.iceConnectionStateChangeHandler)
+	peer.OnConnectionStateChange(viewer.connectionStateChangeHandler)
+
+	return viewer, nil
+}
+
+func (v *Viewer) ID() string {
+	return v.id
+}
+
+// Publisher is the client the viewer watches, it is empty when the viewer watches the whole room
+func (v *Viewer) Publisher() string {
+	return v.publisher
+}
+
+func (v *Viewer) Senders() []*webrtc.RTPSender {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	return v.senders
+}
+
+func (v *Viewer) addTracks(publisher *Connector, allows func(track *webrtc.Track) bool) error {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	for _, local := range publisher.LocalTracks() {
+		if !allows(local) {
+			continue
+		}
+
+		track, bind, err := publisher.subscriberTrack(local, v.id)
+		if err != nil {
+			return err
+		}
+
+		sender, err := v.peer.AddTrack(track)
+		if err != nil {
+			return fmt.Errorf("unable to add viewer track to PeerConnection:%s", err.Error())
+		}
+		bind(sender)
+		v.senders = append(v.senders, sender)
+		v.attached[local] = true
+		go handleSubscriberRTCP(sender, publisher)
+	}
+	v.addPublisher(publisher)
+	return nil
+}
+
+// reserveSlots adds a reserved track for every media section of the offer no track was added for,
+// they use the preferred codec of their kind
+func (v *Viewer) reserveSlots(offer string, policy CodecPolicy) error {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	offered := offeredMedia(offer)
+	for _, sender := range v.senders {
+		offered[sender.Track().Kind()]--
+	}
+
+	mediaEngine := policy.mediaEngine()
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		codecs := mediaEngine.GetCodecsByKind(kind)
+		if len(codecs) == 0 {
+			continue
+		}
+
+		for i := 0; i < offered[kind]; i++ {
+			track, err := v.peer.NewTrack(codecs[0].PayloadType, rand.Uint32(), fmt.Sprintf("viewer-%s-%d", kind, i), v.id)
+			if err != nil {
+				return fmt.Errorf("unable to create reserved viewer track: %w", err)
+			}
+			sender, err := v.peer.AddTrack(track)
+			if err != nil {
+				return fmt.Errorf("unable to add reserved viewer track to PeerConnection: %w", err)
+			}
+
+			slot := &viewerSlot{track: track, sender: sender}
+			v.slots = append(v.slots, slot)
+			go readSubscriberRTCP(sender, func() *Connector {
+				v.mux.Lock()
+				defer v.mux.Unlock()
+				return slot.publisher
+			})
+		}
+	}
+	return nil
+}
+
+// attach forwards the tracks of the publisher the viewer does not receive yet to free reserved tracks
+func (v *Viewer) attach(publisher *Connector, allows func(track *webrtc.Track) bool) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	if v.closed {
+		return
+	}
+
+	for _, local := range publisher.LocalTracks() {
+		if v.attached[local] || !allows(local) {
+			continue
+		}
+		// Static connectors share their tracks, they are only sent to viewers which join after them
+		forwarder := publisher.forwarderOf(local)
+		if forwarder == nil {
+			continue
+		}
+
+		slot := v.freeSlot(local)
+		if slot == nil {
+			log.Printf("[viewer %s] no free %s track for %s, the player has to offer more media sections", v.id, local.Kind(), publisher.ClientID())
+			continue
+		}
+
+		slot.writer = forwarder.subscribeTrack(v.id, slot.track, slot.writer)
+		forwarder.setSender(v.id, slot.sender)
+		slot.publisher = publisher
+		v.attached[local] = true
+		v.addPublisher(publisher)
+	}
+}
+
+// release frees the reserved tracks of a publisher which left the room
+func (v *Viewer) release(publisher *Connector) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	for _, slot := range v.slots {
+		if slot.publisher == publisher {
+			slot.publisher = nil
+		}
+	}
+	publisher.unsubscribeTracks(v.id)
+
+	for _, local := range publisher.LocalTracks() {
+		delete(v.attached, local)
+	}
+}
+
+func (v *Viewer) freeSlot(local *webrtc.Track) *viewerSlot {
+	for _, slot := range v.slots {
+		if slot.publisher == nil && slot.track.Kind() == local.Kind() && slot.track.PayloadType() == local.PayloadType() {
+			return slot
+		}
+	}
+	return nil
+}
+
+func (v *Viewer) addPublisher(publisher *Connector) {
+	for _, other := range v.publishers {
+		if other == publisher {
+			return
+		}
+	}
+	v.publishers = append(v.publishers, publisher)
+}
+
+// offeredMedia counts the audio and video sections of a session description
+func offeredMedia(sdp string) map[webrtc.RTPCodecType]int {
+	offered := make(map[webrtc.RTPCodecType]int)
+	for _, line := range strings.Split(sdp, "\n") {
+		switch {
+		case strings.HasPrefix(line, "m=audio "):
+			offered[webrtc.RTPCodecTypeAudio]++
+		case strings.HasPrefix(line, "m=video "):
+			offered[webrtc.RTPCodecTypeVideo]++
+		}
+	}
+	return offered
+}
+
+func (v *Viewer) answer(offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if err := v.peer.SetRemoteDescription(offer); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("error setting remote description: %w", err)
+	}
+
+	answer, err := v.peer.CreateAnswer(nil)
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("error creating answer: %w", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(v.peer)
+
+	if err := v.peer.SetLocalDescription(answer); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("error setting local description: %w", err)
+	}
+
+	<-gatherComplete
+
+	if local := v.peer.LocalDescription(); local != nil {
+		return *local, nil
+	}
+	return answer, nil
+}
+
+func (v *Viewer) addICECandidate(candidate webrtc.ICECandidateInit) error {
+	if err := v.peer.AddICECandidate(candidate); err != nil {
+		return fmt.Errorf("error adding ice candidate: %w", err)
+	}
+	return nil
+}
+
+func (v *Viewer) iceConnectionStateChangeHandler(connectionState webrtc.ICEConnectionState) {
+	log.Printf("[viewer %s] Peer connection state changed: %s", v.id, connectionState.String())
+	if connectionState == webrtc.ICEConnectionStateClosed ||
+		connectionState == webrtc.ICEConnectionStateFailed {
+
+		if err := v.Close(); err != nil {
+			log.Printf("[viewer %s] unable to close peerConnection: %s", v.id, err.Error())
+		}
+	}
+}
+
+// connectionStateChangeHandler asks the publishers for keyframes once the viewer is able to receive them
+func (v *Viewer) connectionStateChangeHandler(state webrtc.PeerConnectionState) {
+	if state != webrtc.PeerConnectionStateConnected {
+		return
+	}
+
+	v.mux.Lock()
+	publishers := append([]*Connector{}, v.publishers...)
+	v.mux.Unlock()
+
+	for _, publisher := range publishers {
+		publisher.RequestKeyframes()
+	}
+}
+
+func (v *Viewer) Close() (err error) {
+	v.closeOnce.Do(func() {
+		if closeErr := v.peer.Close(); closeErr != nil {
+			err = fmt.Errorf("unable to close viewer peer: %s", closeErr.Error())
+		}
+
+		v.mux.Lock()
+		v.closed = true
+		for _, publisher := range v.publishers {
+			publisher.unsubscribeTracks(v.id)
+		}
+		v.mux.Unlock()
+
+		v.onClose()
+	})
+	return err
+}
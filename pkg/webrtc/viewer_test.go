@@ -0,0 +1,109 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// newTestPublisher has one forwarded VP8 track, like a participant who published a camera
+func newTestPublisher(t *testing.T, clientID string) (*Connector, *forwarder) {
+	template, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, 1, "camera", "pion-video-"+clientID, webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := &Connector{clientID: clientID, forwarders: make(map[string]*forwarder), localTracks: []*webrtc.Track{template}}
+	forwarder := newForwarder("camera", publisher, template, false)
+	publisher.forwarders["camera"] = forwarder
+	t.Cleanup(forwarder.close)
+	return publisher, forwarder
+}
+
+func allowAll(*webrtc.Track) bool {
+	return true
+}
+
+// newTestViewer reserves one video track, the player offered a single video section before anybody published
+func newTestViewer(t *testing.T) *Viewer {
+	viewer, err := newViewer("", DefaultCodecPolicy, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = viewer.Close()
+	})
+
+	if err = viewer.reserveSlots("v=0\nm=video 9 UDP/TLS/RTP/SAVPF 96\n", DefaultCodecPolicy); err != nil {
+		t.Fatal(err)
+	}
+	if len(viewer.slots) != 1 {
+		t.Fatalf("expected one reserved track, got %d", len(viewer.slots))
+	}
+	return viewer
+}
+
+func viewerWriter(f *forwarder, viewer *Viewer) (*subscriberWriter, bool) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	writer, ok := f.writers[viewer.id]
+	return writer, ok
+}
+
+func TestViewerAttachesLatePublisher(t *testing.T) {
+	viewer := newTestViewer(t)
+	alice, aliceForwarder := newTestPublisher(t, "alice")
+	bob, bobForwarder := newTestPublisher(t, "bob")
+
+	viewer.attach(alice, allowAll)
+	slot := viewer.slots[0]
+	if slot.publisher != alice || !viewer.attached[alice.LocalTracks()[0]] {
+		t.Fatal("expected the reserved track to forward the publisher who joined later")
+	}
+	writer, ok := viewerWriter(aliceForwarder, viewer)
+	if !ok || writer.track != slot.track || writer.sender != slot.sender {
+		t.Fatal("expected the forwarder to write to the reserved track")
+	}
+
+	// There is no free track left for the second publisher
+	viewer.attach(bob, allowAll)
+	if _, ok = viewerWriter(bobForwarder, viewer); ok || slot.publisher != alice {
+		t.Fatal("expected the second publisher to get no reserved track")
+	}
+}
+
+func TestViewerReleasesLeavingPublisher(t *testing.T) {
+	viewer := newTestViewer(t)
+	alice, aliceForwarder := newTestPublisher(t, "alice")
+	bob, bobForwarder := newTestPublisher(t, "bob")
+
+	viewer.attach(alice, allowAll)
+	dispatchRaw(t, aliceForwarder, vp8Packet(t, 10, true))
+
+	viewer.release(alice)
+	slot := viewer.slots[0]
+	if slot.publisher != nil || viewer.attached[alice.LocalTracks()[0]] {
+		t.Fatal("expected the reserved track to be free after the publisher left")
+	}
+	if _, ok := viewerWriter(aliceForwarder, viewer); ok {
+		t.Fatal("expected the leaving publisher to stop writing to the viewer")
+	}
+
+	// The next publisher takes the track over and continues its sequence numbers
+	viewer.attach(bob, allowAll)
+	if slot.publisher != bob {
+		t.Fatal("expected the free reserved track to forward the other publisher")
+	}
+	dispatchRaw(t, bobForwarder, vp8Packet(t, 500, true))
+
+	writer, ok := viewerWriter(bobForwarder, viewer)
+	if !ok {
+		t.Fatal("expected the other publisher to write to the viewer")
+	}
+	writer.mux.Lock()
+	sent := writer.lastSeq
+	writer.mux.Unlock()
+	if sent != 11 {
+		t.Fatalf("expected the player to see 11 after 10, got %d", sent)
+	}
+}
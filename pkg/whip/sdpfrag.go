@@ -0,0 +1,77 @@
+package whip
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Fragment is the application/trickle-ice-sdpfrag body of a WHIP PATCH request, see RFC 8840
+type Fragment struct {
+	Ufrag      string
+	Pwd        string
+	Candidates []webrtc.ICECandidateInit
+}
+
+func ParseFragment(body string) (Fragment, error) {
+	var (
+		fragment Fragment
+		mid      *string
+		sections uint16
+	)
+
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "a=ice-ufrag:"):
+			fragment.Ufrag = strings.TrimPrefix(line, "a=ice-ufrag:")
+		case strings.HasPrefix(line, "a=ice-pwd:"):
+			fragment.Pwd = strings.TrimPrefix(line, "a=ice-pwd:")
+		case strings.HasPrefix(line, "m="):
+			sections++
+			mid = nil
+		case strings.HasPrefix(line, "a=mid:"):
+			value := strings.TrimPrefix(line, "a=mid:")
+			mid = &value
+		case strings.HasPrefix(line, "a=candidate:"):
+			var lineIndex uint16
+			if sections > 0 {
+				lineIndex = sections - 1
+			}
+			fragment.Candidates = append(fragment.Candidates, webrtc.ICECandidateInit{
+				Candidate:     strings.TrimPrefix(line, "a="),
+				SDPMid:        mid,
+				SDPMLineIndex: &lineIndex,
+			})
+		}
+	}
+
+	if (fragment.Ufrag == "") != (fragment.Pwd == "") {
+		return Fragment{}, fmt.Errorf("sdpfrag must carry both ice-ufrag and ice-pwd")
+	}
+	return fragment, nil
+}
+
+// IsRestart reports whether the fragment carries ICE credentials, which requests an ICE restart
+func (f Fragment) IsRestart() bool {
+	return f.Ufrag != ""
+}
+
+// answerFragment builds the sdpfrag returned to the client after an ICE restart
+func answerFragment(answer webrtc.SessionDescription) string {
+	var builder strings.Builder
+	for _, line := range strings.Split(answer.SDP, "\r\n") {
+		switch {
+		case strings.HasPrefix(line, "a=ice-ufrag:"),
+			strings.HasPrefix(line, "a=ice-pwd:"),
+			strings.HasPrefix(line, "a=candidate:"),
+			strings.HasPrefix(line, "a=mid:"),
+			strings.HasPrefix(line, "m="):
+			builder.WriteString(line)
+			builder.WriteString("\r\n")
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,95 @@
+// Package whip implements the WebRTC-HTTP ingestion protocol for publish-only clients like OBS or GStreamer
+package whip
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	conference "pion-conference/pkg/webrtc"
+
+	"github.com/google/uuid"
+)
+
+var ErrSessionNotFound = errors.New("whip session not found")
+
+type Service struct {
+	mux      sync.Mutex
+	sessions map[string]*Session
+
+	rooms *conference.RoomsService
+}
+
+func NewService(rooms *conference.RoomsService) *Service {
+	return &Service{
+		sessions: make(map[string]*Session),
+		rooms:    rooms,
+	}
+}
+
+// Publish creates a session in the room and returns it together with the SDP answer to the offer,
+// the participant id is generated so a publisher can not take over the id of another participant
+func (s *Service) Publish(room, offer string) (*Session, string, error) {
+	id := uuid.New().String()
+	clientID := "whip-" + id
+
+	roomCtrl := s.rooms.SetRoomController(room)
+	connector, err := conference.NewPublishConnector(clientID, roomCtrl.CodecPolicy())
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to create whip connector: %w", err)
+	}
+
+	session := &Session{
+		id:        id,
+		room:      room,
+		connector: connector,
+		roomCtrl:  roomCtrl,
+	}
+	if err = session.roomCtrl.Add(connector); err != nil {
+		_ = connector.Close()
+		return nil, "", err
+	}
+
+	s.mux.Lock()
+	s.sessions[id] = session
+	s.mux.Unlock()
+
+	go session.listen(func() {
+		s.remove(id)
+	})
+
+	answer, err := session.answer(offer)
+	if err != nil {
+		_ = s.Stop(id)
+		return nil, "", err
+	}
+
+	return session, answer.SDP, nil
+}
+
+func (s *Service) Session(id string) (*Session, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	session, ok := s.sessions[id]
+	return session, ok
+}
+
+// Stop closes the publisher, the session leaves the room once the connector reports the close
+func (s *Service) Stop(id string) error {
+	session, ok := s.remove(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	return session.connector.Close()
+}
+
+func (s *Service) remove(id string) (*Session, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	session, ok := s.sessions[id]
+	delete(s.sessions, id)
+	return session, ok
+}
@@ -0,0 +1,87 @@
+package whip
+
+import (
+	"log"
+	"sync"
+
+	conference "pion-conference/pkg/webrtc"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Session is one WHIP publisher, its tracks are fanned out to every participant of the room
+type Session struct {
+	mux sync.Mutex
+
+	id        string
+	room      string
+	connector *conference.Connector
+	roomCtrl  *conference.RoomController
+}
+
+func (s *Session) ID() string {
+	return s.id
+}
+
+func (s *Session) Room() string {
+	return s.room
+}
+
+func (s *Session) Connector() *conference.Connector {
+	return s.connector
+}
+
+// Patch applies a trickle-ice-sdpfrag, it returns the answer fragment when the client asked for an ICE restart
+func (s *Session) Patch(fragment Fragment) (string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var answer string
+	if fragment.IsRestart() {
+		restarted, err := s.connector.RestartBroadcastICE(fragment.Ufrag, fragment.Pwd)
+		if err != nil {
+			return "", err
+		}
+		answer = answerFragment(restarted)
+	}
+
+	for _, candidate := range fragment.Candidates {
+		if err := s.connector.AddBroadcastICECandidate(candidate); err != nil {
+			return "", err
+		}
+	}
+
+	return answer, nil
+}
+
+// listen drains the connector channels until the publisher goes away
+func (s *Session) listen(onClose func()) {
+	for {
+		select {
+		case <-s.connector.Signals():
+			// Publish-only connectors never renegotiate with the client
+
+		case <-s.connector.Renegotiates():
+			s.roomCtrl.RenegotiateAll(s.connector)
+
+		case msg := <-s.connector.MessagesChannel():
+			s.roomCtrl.DeliverMessage(msg, s.connector)
+
+		case <-s.connector.Closes():
+			log.Printf("[%s] whip session closed", s.connector.ClientID())
+			s.roomCtrl.Delete(s.connector)
+			onClose()
+			return
+		}
+	}
+}
+
+func (s *Session) answer(offer string) (webrtc.SessionDescription, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.connector.AnswerBroadcastOffer(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offer,
+	})
+}
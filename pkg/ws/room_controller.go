@@ -9,13 +9,13 @@ import (
 
 type RoomController struct {
 	room    string
-	clients map[string]ws.Client
+	clients map[string]*ws.Client
 	mux     sync.RWMutex
 }
 
 func NewRoomController(room string) *RoomController {
 	return &RoomController{
-		clients: make(map[string]ws.Client),
+		clients: make(map[string]*ws.Client),
 		room:    room,
 	}
 }
@@ -34,7 +34,7 @@ func (r *RoomController) GetReadyClients() (map[string]string, error) {
 	return filteredClients, nil
 }
 
-func (r *RoomController) Add(client ws.Client) {
+func (r *RoomController) Add(client *ws.Client) {
 	r.mux.Lock()
 	clientID := client.ID()
 	r.clients[clientID] = client
@@ -124,3 +124,8 @@ func (r *RoomController) emit(clientID string, msg ws.Message) error {
 	}
 	return nil
 }
+
+// Notify broadcasts a room event of the media server, it makes RoomController a webrtc.Notifier
+func (r *RoomController) Notify(event string, payload interface{}) error {
+	return r.Broadcast(ws.NewMessage(event, r.room, payload))
+}
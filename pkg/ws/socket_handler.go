@@ -1,6 +1,8 @@
 package ws
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log"
 	"pion-conference/pkg/models/ws"
@@ -21,6 +23,14 @@ func NewSocketHandler(subsc *Subscription) SocketHandler {
 	}
 }
 
+// Close hangs up a client whose websocket is gone, it may not have sent hangUp before
+func (sh *SocketHandler) Close() error {
+	sh.mux.Lock()
+	defer sh.mux.Unlock()
+
+	return sh.handleHangUp()
+}
+
 func (sh *SocketHandler) HandleMessage(message ws.Message) error {
 	sh.mux.Lock()
 	defer sh.mux.Unlock()
@@ -34,6 +44,29 @@ func (sh *SocketHandler) HandleMessage(message ws.Message) error {
 	case "hangUp":
 		return sh.handleHangUp()
 
+	case "subscribe":
+		return sh.handleSubscription(message, true)
+	case "unsubscribe":
+		return sh.handleSubscription(message, false)
+
+	case "trackMute":
+		return sh.handleTrackMute(message, true)
+	case "trackUnmute":
+		return sh.handleTrackMute(message, false)
+
+	case "stateSet":
+		return sh.handleState(message, false)
+	case "stateDelete":
+		return sh.handleState(message, true)
+
+	case ws.MessageTypeData:
+		return sh.handleData(message)
+
+	case "pin":
+		return sh.handlePin(message, true)
+	case "unpin":
+		return sh.handlePin(message, false)
+
 	case "ping":
 		return nil
 	}
@@ -49,14 +82,27 @@ func (sh *SocketHandler) handleReady(message ws.Message) error {
 
 	sh.subsc.WsRoomCtrl.SetMetadata(sh.subsc.ClientID, payload["nickname"].(string))
 
-	connector, err := webrtc.NewConnector(sh.subsc.ClientID)
+	connector, err := webrtc.NewConnector(sh.subsc.ClientID, sh.subsc.WebRtcRoomCtrl.CodecPolicy())
 	if err != nil {
 		return fmt.Errorf("error creating new webrtc.Connector: %w", err)
 	}
 
-	sh.connector = connector
+	// Large rooms let the client pick the tracks with subscribe messages instead of receiving everything
+	if autoSubscribe, ok := payload["autoSubscribe"].(bool); ok {
+		connector.SetAutoSubscribe(autoSubscribe)
+	}
 
-	sh.subsc.WebRtcRoomCtrl.Add(connector)
+	// Room messages for labels without an open data channel are sent over the websocket
+	connector.SetFallback(func(msg webrtc.ChannelMessage) error {
+		return sh.subsc.WsRoomCtrl.Emit(sh.subsc.ClientID, ws.NewMessageData(sh.subsc.Room, msg.Label, msg.Data, !msg.IsString))
+	})
+
+	if err = sh.subsc.WebRtcRoomCtrl.Add(connector); err != nil {
+		_ = connector.Close()
+		return err
+	}
+
+	sh.connector = connector
 
 	joinMessage := ws.NewMessageRoomJoin(sh.subsc.Room, sh.subsc.ClientID, sh.subsc.WsRoomCtrl.Metadata(sh.subsc.ClientID))
 
@@ -66,6 +112,25 @@ func (sh *SocketHandler) handleReady(message ws.Message) error {
 		return err
 	}
 
+	// Tracks muted before the client joined are not announced again
+	mutesMessage := ws.NewMessage("trackMutes", sh.subsc.Room, sh.subsc.WebRtcRoomCtrl.MutedTracks())
+	if err = sh.subsc.WsRoomCtrl.Emit(sh.subsc.ClientID, mutesMessage); err != nil {
+		log.Printf("error sending muted tracks: %s, %s", sh.subsc.ClientID, err)
+	}
+
+	// The history is replayed over the websocket, the data channels of the client are not open yet
+	if history, err := sh.subsc.WebRtcRoomCtrl.ChatHistory(); err != nil {
+		log.Printf("error loading chat history: %s, %s", sh.subsc.ClientID, err)
+	} else if err = sh.subsc.WsRoomCtrl.Emit(sh.subsc.ClientID, ws.NewMessage("chatHistory", sh.subsc.Room, history)); err != nil {
+		log.Printf("error sending chat history: %s, %s", sh.subsc.ClientID, err)
+	}
+
+	// Changes are sent as stateChange messages, clients keep the entry with the higher version
+	stateMessage := ws.NewMessage("state", sh.subsc.Room, sh.subsc.WebRtcRoomCtrl.State())
+	if err = sh.subsc.WsRoomCtrl.Emit(sh.subsc.ClientID, stateMessage); err != nil {
+		log.Printf("error sending room state: %s, %s", sh.subsc.ClientID, err)
+	}
+
 	go sh.listenConnectorSignals()
 
 	return nil
@@ -85,7 +150,7 @@ func (sh *SocketHandler) listenConnectorSignals() {
 			sh.subsc.WebRtcRoomCtrl.RenegotiateAll(sh.connector)
 
 		case msg := <-sh.connector.MessagesChannel():
-			sh.subsc.WebRtcRoomCtrl.BroadCastMessage(msg, sh.connector)
+			sh.subsc.WebRtcRoomCtrl.DeliverMessage(msg, sh.connector)
 
 		case <-sh.connector.Closes():
 			sh.subsc.WebRtcRoomCtrl.RemoveClosedTracks(sh.connector)
@@ -122,3 +187,146 @@ func (sh *SocketHandler) handleHangUp() error {
 
 	return nil
 }
+
+// handleSubscription changes the tracks the client receives and renegotiates its listen peer,
+// payload is {"clientIds": ["..."], "tracks": ["pion-video-..."]}
+func (sh *SocketHandler) handleSubscription(message ws.Message, subscribe bool) error {
+	payload, ok := message.Payload.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s message payload is of wrong type: %T", message.Type, message.Payload)
+	}
+
+	if sh.connector == nil {
+		return fmt.Errorf("[%s] Ignoring %s because webRTCTransport is not initialized", sh.subsc.ClientID, message.Type)
+	}
+
+	clientIDs, tracks := stringList(payload["clientIds"]), stringList(payload["tracks"])
+	if subscribe {
+		sh.connector.Subscribe(clientIDs, tracks)
+	} else {
+		sh.connector.Unsubscribe(clientIDs, tracks)
+	}
+
+	return sh.subsc.WebRtcRoomCtrl.ApplySubscription(sh.connector)
+}
+
+// handleTrackMute stops forwarding a track of the client and tells the room about it,
+// payload is {"track": "pion-video-..."} or {"kind": "video"}
+func (sh *SocketHandler) handleTrackMute(message ws.Message, muted bool) error {
+	payload, ok := message.Payload.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s message payload is of wrong type: %T", message.Type, message.Payload)
+	}
+
+	if sh.connector == nil {
+		return fmt.Errorf("[%s] Ignoring %s because webRTCTransport is not initialized", sh.subsc.ClientID, message.Type)
+	}
+
+	selector, _ := payload["track"].(string)
+	if selector == "" {
+		selector, _ = payload["kind"].(string)
+	}
+
+	return sh.subsc.WebRtcRoomCtrl.SetTrackMuted(sh.subsc.ClientID, selector, muted)
+}
+
+// handleState writes or deletes a key of the shared room state, the client gets a stateError when it fails,
+// payload is {"key": "...", "value": {...}, "version": 3} where version makes it a compare-and-set
+func (sh *SocketHandler) handleState(message ws.Message, remove bool) error {
+	payload, ok := message.Payload.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s message payload is of wrong type: %T", message.Type, message.Payload)
+	}
+
+	if sh.connector == nil {
+		return fmt.Errorf("[%s] Ignoring %s because webRTCTransport is not initialized", sh.subsc.ClientID, message.Type)
+	}
+
+	update := webrtc.StateUpdate{Delete: remove}
+	update.Key, _ = payload["key"].(string)
+	if version, ok := payload["version"].(float64); ok && version >= 0 {
+		v := uint64(version)
+		update.Version = &v
+	}
+	if value, ok := payload["value"]; ok && !remove {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("[%s] %s value can not be encoded: %w", sh.subsc.ClientID, message.Type, err)
+		}
+		update.Value = raw
+	}
+
+	current, err := sh.subsc.WebRtcRoomCtrl.UpdateState(sh.subsc.ClientID, update)
+	if err != nil {
+		stateError := map[string]interface{}{"key": update.Key, "error": err.Error(), "entry": current}
+		if emitErr := sh.subsc.WsRoomCtrl.Emit(sh.subsc.ClientID, ws.NewMessage("stateError", sh.subsc.Room, stateError)); emitErr != nil {
+			log.Printf("error sending state error: %s, %s", sh.subsc.ClientID, emitErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// handleData delivers a room message of a client without an open data channel,
+// payload is {"label": "chat", "data": "...", "binary": false} with base64 data when binary
+func (sh *SocketHandler) handleData(message ws.Message) error {
+	payload, ok := message.Payload.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s message payload is of wrong type: %T", message.Type, message.Payload)
+	}
+
+	if sh.connector == nil {
+		return fmt.Errorf("[%s] Ignoring %s because webRTCTransport is not initialized", sh.subsc.ClientID, message.Type)
+	}
+
+	label, _ := payload["label"].(string)
+	if label == "" {
+		return fmt.Errorf("[%s] Ignoring %s without label", sh.subsc.ClientID, message.Type)
+	}
+
+	data, _ := payload["data"].(string)
+	binary, _ := payload["binary"].(bool)
+
+	msg := webrtc.ChannelMessage{Label: label}
+	msg.IsString = !binary
+	msg.Data = []byte(data)
+	if binary {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return fmt.Errorf("[%s] %s message data is not base64: %w", sh.subsc.ClientID, message.Type, err)
+		}
+		msg.Data = decoded
+	}
+
+	sh.subsc.WebRtcRoomCtrl.DeliverMessage(msg, sh.connector)
+	return nil
+}
+
+// handlePin keeps the video of a participant forwarded when the room forwards only the Last-N speakers,
+// payload is {"clientId": "..."}
+func (sh *SocketHandler) handlePin(message ws.Message, pinned bool) error {
+	payload, ok := message.Payload.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s message payload is of wrong type: %T", message.Type, message.Payload)
+	}
+
+	if sh.connector == nil {
+		return fmt.Errorf("[%s] Ignoring %s because webRTCTransport is not initialized", sh.subsc.ClientID, message.Type)
+	}
+
+	publisherID, _ := payload["clientId"].(string)
+
+	return sh.subsc.WebRtcRoomCtrl.Pin(sh.subsc.ClientID, publisherID, pinned)
+}
+
+func stringList(value interface{}) []string {
+	items, _ := value.([]interface{})
+
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if str, ok := item.(string); ok {
+			list = append(list, str)
+		}
+	}
+	return list
+}
@@ -43,6 +43,8 @@ func (s *Subscribe) Subscribe(enter api.WsRoomEnter) (*Subscription, error) {
 
 	wsRoomCtrl := s.wsRooms.SetRoomController(enter.RoomId)
 	webrtcRoomCtrl := s.webrtcRooms.SetRoomController(enter.RoomId)
+	webrtcRoomCtrl.SetNotifier(wsRoomCtrl)
+	webrtcRoomCtrl.ReopenChat()
 
 	client := mws.NewClientWithID(enter.Conn, enter.ClientId)
 
@@ -50,7 +52,7 @@ func (s *Subscribe) Subscribe(enter api.WsRoomEnter) (*Subscription, error) {
 
 	ctx := subscribeContext{
 		msg:      msg,
-		client:   &client,
+		client:   client,
 		roomCtrl: wsRoomCtrl,
 	}
 	go s.listenWS(ctx)
@@ -77,11 +79,17 @@ func (s *Subscribe) stopListen(client *mws.Client, ctrl *RoomController) {
 	log.Printf("[%s] RoomController.Remove from room", client.ID())
 	ctrl.Remove(client.ID())
 
-	if err := s.wsRooms.DeleteRoomController(ctrl.Room()); err != nil {
-		log.Printf("RoomsService.DeleteRoomController %s error", ctrl.Room(), err)
+	// Other participants (and bots) keep the room alive
+	if ctrl.Size() == 0 {
+		if err := s.wsRooms.DeleteRoomController(ctrl.Room()); err != nil {
+			log.Printf("RoomsService.DeleteRoomController %s error: %s", ctrl.Room(), err)
+		}
+		if webrtcRoomCtrl, ok := s.webrtcRooms.GetRoomController(ctrl.Room()); ok {
+			webrtcRoomCtrl.EndChat()
+		}
 	}
 
 	if err := client.Close(); err != nil {
-		log.Printf("ws.Client %s close connection  error", client.Close(), err)
+		log.Printf("ws.Client %s close connection error: %s", client.ID(), err)
 	}
 }